@@ -0,0 +1,208 @@
+package scache
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"sync/atomic"
+)
+
+// keyBatch represents keys hashed once and grouped by shard
+type keyBatch struct {
+	keys       [][]byte
+	hashes     []uint64
+	positions  []int //key positions ordered by shard, keys order is preserved within a shard
+	shardsHash uint64
+}
+
+// shards calls fn with positions of keys belonging to each shard
+func (b *keyBatch) shards(fn func(index uint64, positions []int)) {
+	for start := 0; start < len(b.positions); {
+		index := b.hashes[b.positions[start]] & b.shardsHash
+		end := start + 1
+		for ; end < len(b.positions) && b.hashes[b.positions[end]]&b.shardsHash == index; end++ {
+		}
+		fn(index, b.positions[start:end])
+		start = end
+	}
+}
+
+// newKeyBatch creates batch of keys hashed with the keys index hasher
+func (s *Cache) newKeyBatch(keys []string) *keyBatch {
+	hasher := s.segments[atomic.LoadUint32(&s.index)].getShardedMap().hasher
+	batch := &keyBatch{
+		keys:       make([][]byte, len(keys)),
+		hashes:     make([]uint64, len(keys)),
+		positions:  make([]int, len(keys)),
+		shardsHash: s.config.Shards - 1,
+	}
+	for i, key := range keys {
+		batch.keys[i] = keyBytes(key)
+		batch.hashes[i] = hasher.Sum64(batch.keys[i])
+		batch.positions[i] = i
+	}
+	sort.SliceStable(batch.positions, func(i, j int) bool {
+		return batch.hashes[batch.positions[i]]&batch.shardsHash < batch.hashes[batch.positions[j]]&batch.shardsHash
+	})
+	return batch
+}
+
+// MGet returns values for the supplied keys, value is nil for a missing or corrupted key, each shard is locked once per segment,
+// returned values point to the segment data, see Get for their lifetime
+func (s *Cache) MGet(keys []string) ([][]byte, error) {
+	if !s.enter() {
+		return nil, ErrClosed
+	}
+	defer s.exit()
+	atomic.AddUint64(&s.counters.gets, uint64(len(keys)))
+	values := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	batch := s.newKeyBatch(keys)
+	resolved := make([]bool, len(keys))
+	addresses := make([]uint64, len(keys))
+	pending := len(keys)
+	idx := atomic.LoadUint32(&s.index)
+	primary := &s.segments[idx]
+	//keys not found in the current segment are looked up in older generations, found ones are copied to primary
+	for generation := uint32(0); generation < uint32(len(s.segments)) && pending > 0; generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		segment.getShardedMap().getAddresses(batch, resolved, addresses, segment, segment.share)
+		for i := range keys {
+			if resolved[i] || addresses[i] == 0 {
+				continue
+			}
+			resolved[i] = true //expired or negative entry in newer segment shadows older ones
+			pending--
+			_, value, has := segment.entry(addresses[i])
+			flags := segment.flags(addresses[i])
+			if has && flags&largeEntry != 0 {
+				pointer := value
+				if value, has = s.largeValue(batch.keys[i], pointer); has && generation > 0 {
+					s.promote(primary, segment, batch.keys[i], addresses[i], pointer)
+				}
+			} else if has && generation > 0 {
+				value = s.promote(primary, segment, batch.keys[i], addresses[i], value)
+			}
+			if has {
+				var err error
+				if value, err = s.decompress(value, flags); err != nil { //corrupted entry is reported as miss
+					has = false
+				}
+			}
+			if !has || flags&negativeEntry != 0 {
+				atomic.AddUint64(&s.counters.misses, 1)
+				continue
+			}
+			if generation == 0 {
+				atomic.AddUint64(&s.counters.primaryHits, 1)
+			} else {
+				atomic.AddUint64(&s.counters.secondaryHits, 1)
+			}
+			values[i] = value
+		}
+	}
+	atomic.AddUint64(&s.counters.misses, uint64(pending))
+	return values, nil
+}
+
+// MSet sets keys with corresponding values, entries space is reserved in the primary segment at once,
+// entries expire after Config.DefaultTTL if specified
+func (s *Cache) MSet(keys []string, values [][]byte) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	if len(keys) != len(values) {
+		return errors.Errorf("keys count %v does not match values count %v", len(keys), len(values))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	expiry := expiryTime(s.config.DefaultTTL)
+	batch := s.newKeyBatch(keys)
+	entries, flags := values, make([]byte, len(values))
+	if s.config.compressor != nil {
+		entries = make([][]byte, len(values))
+	}
+	for i, key := range batch.keys {
+		if s.large != nil && s.isLarge(key, len(values[i])) {
+			return s.setEach(batch, values)
+		}
+		if s.config.compressor != nil {
+			entries[i], flags[i] = s.compress(values[i])
+		}
+		if err := s.checkEntry(key, len(entries[i]), flags[i]); err != nil {
+			return err
+		}
+	}
+	var evicted [][]byte
+	if s.OnEvict != nil { //replaced values are notified once the batch is set
+		evicted = make([][]byte, len(batch.keys))
+		for i, key := range batch.keys {
+			evicted[i], _ = s.evicted(key)
+		}
+	}
+	version := s.nextVersion(len(keys))
+	idx := atomic.LoadUint32(&s.index)
+	if !s.segments[idx].setAll(batch, entries, flags, expiry, version) {
+		s.switchSegment(idx)
+		idx = atomic.LoadUint32(&s.index)
+		if !s.segments[idx].setAll(batch, entries, flags, expiry, version) { //batch does not fit a segment, set keys one by one
+			return s.setEach(batch, values)
+		}
+	}
+	if s.large != nil {
+		for _, key := range batch.keys {
+			s.large.delete(key, 0)
+		}
+	}
+	for i, value := range evicted {
+		if value != nil {
+			s.OnEvict(string(batch.keys[i]), value, EvictOverwrite)
+		}
+	}
+	atomic.AddUint64(&s.counters.sets, uint64(len(keys)))
+	for _, value := range entries {
+		atomic.AddUint64(&s.counters.bytesWritten, uint64(len(value)))
+	}
+	return nil
+}
+
+// setEach sets batch keys one by one
+func (s *Cache) setEach(batch *keyBatch, values [][]byte) error {
+	for i := range batch.keys {
+		if err := s.set(batch.keys[i], values[i], s.config.DefaultTTL, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MDelete deletes keys in the cache, keys are removed from the oldest generation first
+func (s *Cache) MDelete(keys []string) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	atomic.AddUint64(&s.counters.deletes, uint64(len(keys)))
+	if len(keys) == 0 {
+		return nil
+	}
+	batch := s.newKeyBatch(keys)
+	if s.OnEvict != nil {
+		for _, key := range batch.keys {
+			s.notifyEvict(key, EvictDelete)
+		}
+	}
+	idx := atomic.LoadUint32(&s.index)
+	for generation := len(s.segments) - 1; generation >= 0; generation-- {
+		s.segments[s.olderIndex(idx, uint32(generation))].deleteAll(batch)
+	}
+	if s.large != nil {
+		for _, key := range batch.keys {
+			s.large.delete(key, 0)
+		}
+	}
+	return nil
+}
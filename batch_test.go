@@ -0,0 +1,101 @@
+package scache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCache_MSet(t *testing.T) {
+	var useCases = []struct {
+		description string
+		config      *Config
+		keys        int
+		valueSize   int
+	}{
+		{
+			description: "small batch",
+			config:      &Config{SizeMb: 1},
+			keys:        10,
+			valueSize:   16,
+		},
+		{
+			description: "fan-out batch",
+			config:      &Config{SizeMb: 4, Shards: 64},
+			keys:        200,
+			valueSize:   128,
+		},
+		{
+			description: "batch exceeding segment size",
+			config:      &Config{SizeMb: 2},
+			keys:        1000,
+			valueSize:   2048,
+		},
+	}
+
+	for _, useCase := range useCases {
+		cache, err := New(useCase.config)
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		keys := make([]string, useCase.keys)
+		values := make([][]byte, useCase.keys)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key%v", i)
+			values[i] = []byte(fmt.Sprintf("%0*d", useCase.valueSize, i))
+		}
+		assert.Nil(t, cache.MSet(keys, values), useCase.description)
+		actual, err := cache.MGet(keys)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, len(keys), len(actual), useCase.description)
+		found := 0
+		for i := range keys {
+			if actual[i] == nil {
+				continue
+			}
+			found++
+			assert.EqualValues(t, values[i], actual[i], useCase.description)
+			value, err := cache.Get(keys[i])
+			assert.Nil(t, err, useCase.description)
+			assert.EqualValues(t, values[i], value, useCase.description)
+		}
+		assert.True(t, found > 0, useCase.description)
+		assert.Nil(t, cache.MDelete(keys), useCase.description)
+		actual, err = cache.MGet(keys)
+		assert.Nil(t, err, useCase.description)
+		for i, value := range actual {
+			assert.Nil(t, value, useCase.description+" "+keys[i])
+		}
+		_ = cache.Close()
+	}
+}
+
+func TestCache_MGet(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, Segments: 3})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	assert.Nil(t, cache.Set("k1", []byte("v1")))
+	cache.switchSegment(cache.index)
+	assert.Nil(t, cache.Set("k2", []byte("v2")))
+	cache.switchSegment(cache.index)
+	assert.Nil(t, cache.MSet([]string{"k3", "k3"}, [][]byte{[]byte("old"), []byte("v3")}))
+	assert.Nil(t, cache.set([]byte("k4"), nil, 0, negativeEntry))
+
+	values, err := cache.MGet([]string{"k1", "k2", "k3", "k4", "k5", "k1"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, [][]byte{[]byte("v1"), []byte("v2"), []byte("v3"), nil, nil, []byte("v1")}, values)
+	primary := &cache.segments[cache.index]
+	for _, key := range []string{"k1", "k2"} {
+		_, has := primary.get([]byte(key))
+		assert.True(t, has, "older generation key should be promoted: "+key)
+	}
+	assert.EqualValues(t, 4, primary.keys) //k3, negative k4 and promoted k1, k2
+
+	assert.NotNil(t, cache.MSet([]string{"k1"}, nil))
+	assert.Nil(t, cache.MDelete([]string{"k1", "k5"}))
+	values, err = cache.MGet([]string{"k1", "k2"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, [][]byte{nil, []byte("v2")}, values)
+}
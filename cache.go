@@ -6,125 +6,558 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
-const (
-	segmentsSize     = 2
-	maxSupportedSize = 256 * 1024
-)
-
-// Cache represents cache service
+// Cache represents cache service, segments rotate as a ring: the primary one is read/write active,
+// older generations are read only active
 type Cache struct {
 	config   *Config
-	segments [segmentsSize]segment
+	segments []segment
 	index    uint32
 	mutex    sync.Mutex
 	mmap     *mmap
+	header   *fileHeader
+	counters counters
+	//spare cleared keys index swapped with the index of the segment being recycled on segment switch
+	spare *shardedMap
+	//evicting is closed once segment switch evicting recycled segment entries completes
+	evicting chan struct{}
+	loads    loadGroup
+	large    *largeArea
+	//version last assigned entry version
+	version uint64
+	closed  int32
+	//inflight counts operations in progress, Close waits for them before segments data is unmapped
+	inflight int32
+	//drained is closed by the last in-flight operation exiting closed cache
+	drained   chan struct{}
+	drainOnce sync.Once
 	OnSegmentSwitch
-	*shardedMap
+	OnEvict
 }
 
+// nextIndex returns index of the segment following the supplied one in the ring, which is the oldest generation
 func (s *Cache) nextIndex(idx uint32) uint32 {
-	next := uint32(0)
-	if idx == 0 {
-		next = 1
-	}
-	return next
+	return (idx + 1) % uint32(len(s.segments))
+}
+
+// olderIndex returns index of the segment being supplied number of generations older than the supplied one
+func (s *Cache) olderIndex(idx, generation uint32) uint32 {
+	count := uint32(len(s.segments))
+	return (idx + count - generation) % count
 }
 
-func (s *Cache) newShardedMap() *shardedMap {
-	result := s.shardedMap
-	s.shardedMap = nil
-	if result == nil {
-		result = newShardedMap(s.config)
+// switchSegment makes the oldest generation segment the primary one, recycled segment keys index is swapped
+// with the spare one, so that the switch does not wait for keys removal, detached index is cleared in background.
+// With OnEvict listener detached index entries are evicted without the switch lock before the segment data is reused,
+// concurrent switches wait for the eviction to complete, thus the switch time grows with the recycled segment keys
+func (s *Cache) switchSegment(idx uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if currIdx := atomic.LoadUint32(&s.index); currIdx != idx {
+		return
 	}
-	go func() {
+	if evicting := s.evicting; evicting != nil {
+		s.mutex.Unlock()
+		<-evicting
 		s.mutex.Lock()
-		// preallocate new keys space, for next segment switch
-		s.shardedMap = newShardedMap(s.config)
+		return
+	}
+	startTime := time.Now()
+	nextIndex := s.nextIndex(idx)
+	spare := s.spare
+	s.spare = nil
+	fn := s.OnEvict
+	if fn != nil && spare == nil { //evicted entries are read from detached index
+		spare = newShardedMap(s.config)
+	}
+	recycled := &s.segments[nextIndex]
+	detached := recycled.detach(spare)
+	if fn != nil {
+		evicting := make(chan struct{})
+		s.evicting = evicting
+		defer close(evicting)
+		newer := make([]*segment, 0, len(s.segments)-1)
+		for generation := uint32(0); generation < uint32(len(s.segments)-1); generation++ {
+			newer = append(newer, &s.segments[s.olderIndex(idx, generation)])
+		}
 		s.mutex.Unlock()
-	}()
-	return result
+		s.evictSegment(fn, recycled, detached, newer)
+		s.mutex.Lock()
+		s.evicting = nil
+	}
+	recycled.recycle()
+	atomic.StoreUint32(&s.index, nextIndex)
+	atomic.AddUint64(&s.counters.switches, 1)
+	if detached != nil {
+		go s.prepareSpare(detached)
+	}
+	if fn := s.OnSegmentSwitch; fn != nil {
+		fn(idx, atomic.LoadUint32(&s.segments[idx].keys), time.Now().Sub(startTime))
+	}
 }
 
-// Set sets key with value or error
+// prepareSpare clears detached keys index to be used as spare for the next segment switch
+func (s *Cache) prepareSpare(detached *shardedMap) {
+	detached.clear()
+	s.mutex.Lock()
+	if s.spare == nil {
+		s.spare = detached
+	}
+	s.mutex.Unlock()
+}
+
+// keyBytes returns string key bytes without copying, returned bytes must not be modified
+func keyBytes(key string) []byte {
+	return unsafe.Slice(unsafe.StringData(key), len(key))
+}
+
+// Set sets key with value or error, entry expires after Config.DefaultTTL if specified
 func (s *Cache) Set(key string, value []byte) error {
+	return s.set(keyBytes(key), value, s.config.DefaultTTL, 0)
+}
+
+// SetBytes sets byte slice key with value or error, entry expires after Config.DefaultTTL if specified
+func (s *Cache) SetBytes(key []byte, value []byte) error {
+	return s.set(key, value, s.config.DefaultTTL, 0)
+}
+
+// SetWithTTL sets key with value that expires after supplied ttl, zero ttl means no expiry,
+// negative ttl stores already expired entry, thus the key is treated as missing
+func (s *Cache) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return s.set(keyBytes(key), value, ttl, 0)
+}
+
+// expiryTime returns entry expiry unix nano time for the supplied ttl, zero if entry does not expire
+func expiryTime(ttl time.Duration) uint64 {
+	if ttl == 0 {
+		return 0
+	}
+	if expiry := time.Now().Add(ttl).UnixNano(); expiry > 0 {
+		return uint64(expiry)
+	}
+	return 1 //already expired
+}
+
+func (s *Cache) set(key []byte, value []byte, ttl time.Duration, flags byte) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	expiry := expiryTime(ttl)
+	if flags == 0 {
+		if s.large != nil && s.isLarge(key, len(value)) {
+			return s.setLarge(key, value, nil, ttl)
+		}
+		value, flags = s.compress(value)
+	}
 	idx := atomic.LoadUint32(&s.index)
-	_, isSet := s.segments[idx].set(key, value)
+	if err := s.checkEntry(key, len(value), flags); err != nil {
+		return err
+	}
+	var evicted []byte
+	hasEvicted := false
+	if s.OnEvict != nil { //replaced value is notified once the new one is set
+		evicted, hasEvicted = s.evicted(key)
+	}
+	version := s.nextVersion(1)
+	_, isSet := s.segments[idx].setEntry(key, value, expiry, flags, version)
 	if !isSet {
-		nextIndex := s.nextIndex(idx)
-		s.mutex.Lock()
-		if currIdx := atomic.LoadUint32(&s.index); currIdx == idx {
-
-			startTime := time.Now()
-			fn := s.OnSegmentSwitch
-			s.segments[nextIndex].reset()
-			atomic.StoreUint32(&s.index, nextIndex)
-			if fn != nil {
-				fn(idx, atomic.LoadUint32(&s.segments[idx].keys), time.Now().Sub(startTime))
-			}
-		}
-		s.mutex.Unlock()
+		s.switchSegment(idx)
 		idx = atomic.LoadUint32(&s.index)
-		if _, ok := s.segments[idx].set(key, value); !ok {
-			return errors.Errorf("failed to set key: %v", key)
+		if _, ok := s.segments[idx].setEntry(key, value, expiry, flags, version); !ok {
+			return s.setError(&s.segments[idx], key)
 		}
 
 	}
+	if s.large != nil { //previous large value is replaced
+		s.large.delete(key, 0)
+	}
+	if hasEvicted {
+		s.OnEvict(string(key), evicted, EvictOverwrite)
+	}
+	atomic.AddUint64(&s.counters.sets, 1)
+	atomic.AddUint64(&s.counters.bytesWritten, uint64(len(value)))
 	return nil
 }
 
-// Delete deletes key in the cache
+// Delete deletes key in the cache, key is removed from the oldest generation first to prevent its promotion to the primary segment
 func (s *Cache) Delete(key string) error {
+	return s.delete(keyBytes(key))
+}
+
+// DeleteBytes deletes byte slice key in the cache
+func (s *Cache) DeleteBytes(key []byte) error {
+	return s.delete(key)
+}
+
+func (s *Cache) delete(key []byte) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	atomic.AddUint64(&s.counters.deletes, 1)
+	if s.OnEvict != nil {
+		s.notifyEvict(key, EvictDelete)
+	}
 	idx := atomic.LoadUint32(&s.index)
-	s.segments[idx].delete(key)
+	for generation := len(s.segments) - 1; generation >= 0; generation-- {
+		s.segments[s.olderIndex(idx, uint32(generation))].delete(key)
+	}
+	if s.large != nil {
+		s.large.delete(key, 0)
+	}
 	return nil
 }
 
-// Get returns a cache entry for the supplied key or error
+// checkEntry returns ErrEntryTooLarge if entry does not fit an empty segment, so that set does not switch segments in vain
+func (s *Cache) checkEntry(key []byte, valueSize int, flags byte) error {
+	if !s.segments[0].fits(len(key), valueSize, flags) {
+		atomic.AddUint64(&s.counters.failedSets, 1)
+		return errors.Wrapf(ErrEntryTooLarge, "failed to set key: %s, value size: %v", key, valueSize)
+	}
+	return nil
+}
+
+// setError returns error of entry that failed to be set to the supplied segment after segment switch
+func (s *Cache) setError(segment *segment, key []byte) error {
+	atomic.AddUint64(&s.counters.failedSets, 1)
+	if !segment.canAdd(1) {
+		return errors.Wrapf(ErrMaxEntries, "failed to set key: %s", key)
+	}
+	return errors.Errorf("failed to set key: %s", key)
+}
+
+// enter registers in-flight operation, it returns false when cache is closed
+func (s *Cache) enter() bool {
+	atomic.AddInt32(&s.inflight, 1)
+	if atomic.LoadInt32(&s.closed) == 1 {
+		s.exit()
+		return false
+	}
+	return true
+}
+
+// exit unregisters in-flight operation, the last one exiting closed cache signals Close waiting for in-flight operations
+func (s *Cache) exit() {
+	if atomic.AddInt32(&s.inflight, -1) == 0 && atomic.LoadInt32(&s.closed) == 1 {
+		s.drainOnce.Do(func() {
+			close(s.drained)
+		})
+	}
+}
+
+// promote copies older generation segment entry to the primary segment and returns primary segment value marked shared,
+// entry is copied only if the primary segment does not store the key, so that concurrently written newer value is kept,
+// if the key was deleted from the older segment in the meantime, promoted entry is removed to not resurrect deleted value
+func (s *Cache) promote(primary, secondary *segment, key []byte, headerAddress uint64, value []byte) []byte {
+	version := secondary.version(headerAddress)
+	if _, ok := primary.setIf(key, value, secondary.expiry(headerAddress), secondary.flags(headerAddress), func(current uint64) (uint64, bool) {
+		return version, current == 0
+	}); !ok {
+		return value
+	}
+	if _, has := secondary.get(key); !has {
+		primary.deleteVersion(key, version)
+		return value
+	}
+	if promotedAddress, promoted, has := primary.lookupShared(key); has && primary.version(promotedAddress) == version {
+		return promoted
+	}
+	return value
+}
+
+// entryValue returns value of the entry with the supplied flags, large value is read from the large object area
+// and compressed value is decompressed, it returns false if large value was evicted or value can not be decompressed
+func (s *Cache) entryValue(key []byte, value []byte, flags byte) ([]byte, bool) {
+	if flags&largeEntry != 0 {
+		var has bool
+		if value, has = s.largeValue(key, value); !has {
+			return nil, false
+		}
+	}
+	value, err := s.decompress(value, flags)
+	return value, err == nil
+}
+
+// Get returns a cache entry for the supplied key or error, returned value points to the segment data that is reused
+// after subsequent segment switches, use View, GetInto or AppendTo when value outlives segment switch,
+// entry returned by Get is never overwritten in place, thus the next Set of the key appends a new entry
 func (s *Cache) Get(key string) ([]byte, error) {
+	return s.GetBytes(keyBytes(key))
+}
+
+// GetBytes returns a cache entry for the supplied byte slice key or error, see Get for returned value lifetime
+func (s *Cache) GetBytes(key []byte) ([]byte, error) {
+	value, _, _, err := s.get(key)
+	return value, err
+}
+
+// get returns value and version for the supplied key or error, negative flag is returned when key absence was cached,
+// corrupted entry returns ErrCorrupted
+func (s *Cache) get(key []byte) ([]byte, uint64, bool, error) {
+	if !s.enter() {
+		return nil, 0, false, ErrClosed
+	}
+	defer s.exit()
+	atomic.AddUint64(&s.counters.gets, 1)
 	idx := atomic.LoadUint32(&s.index)
-	value, has := s.segments[idx].get(key)
-	if !has { //if not found in the current segment find in secondary, when  found copy to primary
-		nextIndex := s.nextIndex(idx)
-		if value, has = s.segments[nextIndex].get(key); has {
-			value, _ = s.segments[idx].set(key, value) //return buffer from primary  segment
+	primary := &s.segments[idx]
+	//if not found in the current segment find in older generations, when  found copy to primary
+	for generation := uint32(0); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		headerAddress, value, has := segment.lookupShared(key) //returned value must not be overwritten in place
+		if has {
+			if segment.flags(headerAddress)&negativeEntry != 0 { //cached key absence shadows older generations
+				atomic.AddUint64(&s.counters.misses, 1)
+				return nil, 0, true, noSuchKeyErr
+			}
+			version := segment.version(headerAddress)
+			flags := segment.flags(headerAddress)
+			if flags&largeEntry != 0 {
+				pointer := value
+				if value, has = s.largeValue(key, pointer); !has { //large value was evicted
+					break
+				}
+				if generation > 0 {
+					s.promote(primary, segment, key, headerAddress, pointer)
+				}
+			} else if generation > 0 {
+				value = s.promote(primary, segment, key, headerAddress, value) //return buffer from primary  segment
+			}
+			var err error
+			if value, err = s.decompress(value, flags); err != nil {
+				atomic.AddUint64(&s.counters.misses, 1)
+				return nil, 0, false, err
+			}
+			if generation == 0 {
+				atomic.AddUint64(&s.counters.primaryHits, 1)
+			} else {
+				atomic.AddUint64(&s.counters.secondaryHits, 1)
+			}
+			return value, version, false, nil
+		}
+		if headerAddress != 0 { //expired entry in newer segment shadows older ones
+			if segment.isCorrupted(headerAddress) {
+				atomic.AddUint64(&s.counters.misses, 1)
+				return nil, 0, false, ErrCorrupted
+			}
+			break
 		}
 	}
-	if !has {
-		return nil, noSuchKeyErr
+	atomic.AddUint64(&s.counters.misses, 1)
+	return nil, 0, false, noSuchKeyErr
+}
+
+// View calls fn with value for the supplied key without copying it, value data is guaranteed not to be reused
+// until fn returns, fn must not retain the value nor modify the cache, large value is copied before fn call
+func (s *Cache) View(key string, fn func(value []byte)) error {
+	return s.view(keyBytes(key), func(value []byte, _ uint64) {
+		fn(value)
+	}, nil)
+}
+
+// view calls fn with the key value and version, if large function is supplied, it is called with acquired large entry instead,
+// which has to be released by the caller
+func (s *Cache) view(key []byte, fn func(value []byte, version uint64), large func(entry *largeObject)) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	atomic.AddUint64(&s.counters.gets, 1)
+	idx := atomic.LoadUint32(&s.index)
+	primary := &s.segments[idx]
+	for generation := uint32(0); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		if !segment.acquire() {
+			continue
+		}
+		headerAddress, value, has := segment.leaseEntry(key) //value is not overwritten in place until fn returns
+		if has && segment.flags(headerAddress)&negativeEntry != 0 {
+			segment.releaseEntry(headerAddress)
+			segment.release()
+			break
+		}
+		if has {
+			var entry *largeObject
+			if segment.flags(headerAddress)&largeEntry != 0 {
+				if entry, has = s.acquireLarge(key, value); !has { //large value was evicted
+					segment.releaseEntry(headerAddress)
+					segment.release()
+					break
+				}
+			}
+			if generation == 0 {
+				atomic.AddUint64(&s.counters.primaryHits, 1)
+			} else {
+				atomic.AddUint64(&s.counters.secondaryHits, 1)
+				s.promote(primary, segment, key, headerAddress, value)
+			}
+			switch {
+			case entry != nil && large != nil:
+				large(entry)
+			case entry != nil:
+				fn(s.large.appendTo(make([]byte, 0, entry.size), entry), segment.version(headerAddress))
+				s.large.releaseEntry(entry)
+			default:
+				value, err := s.decompress(value, segment.flags(headerAddress))
+				if err != nil {
+					segment.releaseEntry(headerAddress)
+					segment.release()
+					return err
+				}
+				fn(value, segment.version(headerAddress))
+			}
+			segment.releaseEntry(headerAddress)
+			segment.release()
+			return nil
+		}
+		corrupted := headerAddress != 0 && segment.isCorrupted(headerAddress)
+		segment.releaseEntry(headerAddress)
+		segment.release()
+		if corrupted {
+			atomic.AddUint64(&s.counters.misses, 1)
+			return ErrCorrupted
+		}
+		if headerAddress != 0 { //expired entry in newer segment shadows older ones
+			break
+		}
+	}
+	atomic.AddUint64(&s.counters.misses, 1)
+	return noSuchKeyErr
+}
+
+// AppendTo appends value for the supplied key to dst and returns the extended buffer
+func (s *Cache) AppendTo(dst []byte, key string) ([]byte, error) {
+	err := s.View(key, func(value []byte) {
+		dst = append(dst, value...)
+	})
+	return dst, err
+}
+
+// GetInto copies value for the supplied key into dst, dst is reallocated only if its capacity is not sufficient
+func (s *Cache) GetInto(key string, dst []byte) ([]byte, error) {
+	return s.AppendTo(dst[:0], key)
+}
+
+// Sync flushes memory mapped file segments data to the file, it does nothing for memory cache
+func (s *Cache) Sync() error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	for i := range s.segments {
+		if err := s.segments[i].sync(); err != nil {
+			return err
+		}
 	}
-	return value, nil
+	return nil
 }
 
-// Close closes the Cache
+// Close closes the Cache once in-flight operations complete, subsequent operations return ErrClosed,
+// memory mapped file cache persists key index to be restored by the subsequent New call, syncs and unmaps segments data,
+// thus values returned by Get must not be used after Close, Close must not be called from View or Range callback
 func (s *Cache) Close() (err error) {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return ErrClosed
+	}
+	if atomic.LoadInt32(&s.inflight) > 0 {
+		<-s.drained
+	}
+	if s.config.Location != "" {
+		if err = s.persistIndex(); err == nil {
+			for i := range s.segments {
+				if err = s.segments[i].sync(); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = s.markClean(true)
+		}
+	}
+	if e := s.release(); e != nil {
+		err = e
+	}
+	return err
+}
+
+// release unmaps segments data and closes memory mapped file
+func (s *Cache) release() (err error) {
 	for i := range s.segments {
 		if e := s.segments[i].close(); e != nil {
 			err = e
 		}
 	}
+	if s.mmap != nil {
+		if e := s.mmap.close(); e != nil {
+			err = e
+		}
+	}
 	return err
 }
 
-// New creates a Cache
+// New creates a Cache, cache uses its own copy of initialised config, so that key hasher and hash seed resolved
+// for the cache are never written back to the supplied config, which can be shared by multiple caches
 func New(config *Config) (*Cache, error) {
 	config.Init()
-	if config.SizeMb > maxSupportedSize {
-		//given 32 bytes data alignment max addressable space is 256GB
-		return nil, fmt.Errorf("exceeded max supported cache size: 256GB")
+	cacheConfig := *config
+	config = &cacheConfig
+	if err := config.validateAlignment(); err != nil {
+		return nil, err
+	}
+	//given uint32 entry address, max addressable segment space is 32GB for 8 bytes alignment up to 256GB for 64 bytes alignment
+	if maxSize := config.maxSupportedSize(); uint64(config.SizeMb)*mb/uint64(config.Segments) > maxSize {
+		return nil, fmt.Errorf("exceeded max supported segment size: %vGB", maxSize/(1024*mb))
+	}
+	if size := config.SegmentDataSize(); size < minSegmentSize {
+		return nil, fmt.Errorf("segment size %v is below min supported size %v, increase SizeMb or decrease Segments", size, minSegmentSize)
+	}
+	var err error
+	if config.hasher, err = config.newHasher(); err != nil {
+		return nil, err
+	}
+	if config.compressor, err = config.newCompressor(); err != nil {
+		return nil, err
 	}
 	var cache = &Cache{
-		config: config,
+		config:   config,
+		segments: make([]segment, config.Segments),
+		version:  uint64(time.Now().UnixNano()), //versions keep growing across memory mapped file cache restarts
+		drained:  make(chan struct{}),
+	}
+	clean := false
+	if config.Location != "" {
+		if clean, err = cache.openFile(); err != nil {
+			if cache.mmap.file != nil {
+				_ = cache.mmap.close()
+			}
+			return nil, err
+		}
 	}
 	for i := range cache.segments {
 		cache.segments[i].config = config
 		cache.segments[i].shardedMap = newShardedMap(config)
 		if err := cache.segments[i].allocate(i); err != nil {
+			cache.release()
+			return nil, err
+		}
+	}
+	cache.spare = newShardedMap(config)
+	if config.LargeSizeMb > 0 {
+		cache.large = newLargeArea(config.LargeSizeMb)
+	}
+	if config.Location != "" {
+		if err := cache.loadIndex(clean); err != nil {
+			cache.release()
+			return nil, err
+		}
+		if err := cache.markClean(false); err != nil {
+			cache.release()
 			return nil, err
 		}
 	}
-	cache.shardedMap = newShardedMap(config)
 	return cache, nil
 }
 
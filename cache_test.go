@@ -2,8 +2,10 @@ package scache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"math/rand"
 	"runtime"
 	"strconv"
@@ -37,7 +39,25 @@ func TestNewCache(t *testing.T) {
 		{
 			description: "memory mapped file",
 			entrySize:   1024,
-			config:      &Config{SizeMb: 12, Location: "/tmp/scache"},
+			config:      &Config{SizeMb: 12, Location: "/tmp/scache", ReinitializeOnMismatch: true},
+			keys:        32 * 1024,
+		},
+		{
+			description: "generational segments",
+			entrySize:   1024,
+			config:      &Config{SizeMb: 12, Segments: 5},
+			keys:        32 * 1024,
+		},
+		{
+			description: "8 bytes alignment",
+			entrySize:   8,
+			config:      &Config{SizeMb: 1, Alignment: 8},
+			keys:        32 * 1024,
+		},
+		{
+			description: "64 bytes alignment",
+			entrySize:   100,
+			config:      &Config{SizeMb: 2, Alignment: 64},
 			keys:        32 * 1024,
 		},
 	}
@@ -61,6 +81,8 @@ func TestNewCache(t *testing.T) {
 		}
 		cache.Close()
 	}
+	_, err := New(&Config{SizeMb: 1, Segments: 32})
+	assert.NotNil(t, err, "segment size below min supported size")
 }
 
 func TestCacheMultiOperation(t *testing.T) {
@@ -167,10 +189,11 @@ func readFromCacheParallel(b *testing.B, payload []byte, location string) {
 
 func initCache(entries, entrySize int, location string) *Cache {
 	cfg := &Config{
-		Location:   location,
-		Shards:     256,
-		EntrySize:  entrySize,
-		MaxEntries: 2 * entries,
+		Location:               location,
+		Shards:                 256,
+		EntrySize:              entrySize,
+		MaxEntries:             2 * entries,
+		ReinitializeOnMismatch: true,
 	}
 	cfg.Init()
 	var m runtime.MemStats
@@ -270,3 +293,652 @@ func TestConcurrency(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCache_SetWithTTL(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, DefaultTTL: 50 * time.Millisecond})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	assert.Nil(t, cache.Set("default", []byte("v1")))
+	assert.Nil(t, cache.SetWithTTL("short", []byte("v2"), 10*time.Millisecond))
+	assert.Nil(t, cache.SetWithTTL("forever", []byte("v3"), 0))
+	for _, key := range []string{"default", "short", "forever"} {
+		_, err := cache.Get(key)
+		assert.Nil(t, err, key)
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, err = cache.Get("short")
+	assert.NotNil(t, err)
+	_, err = cache.Get("default")
+	assert.Nil(t, err)
+
+	//negative ttl stores already expired entry replacing the current value
+	assert.Nil(t, cache.SetWithTTL("negative", []byte("v5"), -time.Second))
+	assert.Nil(t, cache.SetWithTTL("replaced", []byte("v6"), 0))
+	assert.Nil(t, cache.SetWithTTL("replaced", []byte("v7"), time.Duration(math.MinInt64)))
+	for _, key := range []string{"negative", "replaced"} {
+		_, err = cache.Get(key)
+		assert.NotNil(t, err, key)
+	}
+
+	//expired entries in secondary segment are not promoted
+	idx := atomic.LoadUint32(&cache.index)
+	assert.Nil(t, cache.SetWithTTL("secondary", []byte("v4"), 10*time.Millisecond))
+	atomic.StoreUint32(&cache.index, cache.nextIndex(idx))
+	time.Sleep(20 * time.Millisecond)
+	_, err = cache.Get("secondary")
+	assert.NotNil(t, err)
+	_, has := cache.segments[cache.nextIndex(idx)].get([]byte("secondary"))
+	assert.False(t, has)
+
+	time.Sleep(40 * time.Millisecond)
+	_, err = cache.Get("default")
+	assert.NotNil(t, err)
+	value, err := cache.Get("forever")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v3", string(value))
+}
+
+func TestCache_PersistIndex(t *testing.T) {
+	location := t.TempDir() + "/scache.mmap"
+	config := &Config{SizeMb: 2, Location: location}
+	cache, err := New(config)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("key%v", i), []byte(fmt.Sprintf("value%v", i))))
+	}
+	assert.Nil(t, cache.Delete("key0"))
+	index := cache.index
+	assert.Nil(t, cache.Close())
+
+	cache, err = New(&Config{SizeMb: 2, Location: location})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.EqualValues(t, index, cache.index)
+	_, err = cache.Get("key0")
+	assert.NotNil(t, err)
+	for i := 1; i < 1000; i++ {
+		value, err := cache.Get(fmt.Sprintf("key%v", i))
+		if assert.Nil(t, err) {
+			assert.EqualValues(t, fmt.Sprintf("value%v", i), string(value))
+		}
+	}
+	assert.Nil(t, cache.Set("key1000", []byte("value1000")))
+	value, err := cache.Get("key1000")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value1000", string(value))
+	assert.Nil(t, cache.Close())
+
+	//unclean shutdown discards persisted index
+	cache, err = New(&Config{SizeMb: 2, Location: location})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, cache.persistIndex())
+	for i := range cache.segments {
+		assert.Nil(t, cache.segments[i].close())
+	}
+	assert.Nil(t, cache.mmap.close())
+	cache, err = New(&Config{SizeMb: 2, Location: location})
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = cache.Get("key1")
+	assert.NotNil(t, err)
+	assert.Nil(t, cache.Set("key1", []byte("value1")))
+	assert.Nil(t, cache.Close())
+
+	//changed layout is reported unless file is reinitialized
+	_, err = New(&Config{SizeMb: 4, Location: location})
+	mismatch := &LayoutMismatch{}
+	if assert.True(t, errors.As(err, &mismatch)) {
+		assert.EqualValues(t, "SegmentSize", mismatch.Field)
+	}
+	cache, err = New(&Config{SizeMb: 4, Location: location, ReinitializeOnMismatch: true})
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = cache.Get("key1")
+	assert.NotNil(t, err)
+	assert.Nil(t, cache.Close())
+}
+
+func TestCache_View(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	assert.Nil(t, cache.Set("key1", []byte("value1")))
+
+	var actual string
+	assert.Nil(t, cache.View("key1", func(value []byte) {
+		actual = string(value)
+	}))
+	assert.EqualValues(t, "value1", actual)
+	assert.NotNil(t, cache.View("key2", func(value []byte) {}))
+
+	buffer := make([]byte, 0, 64)
+	value, err := cache.GetInto("key1", buffer)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value1", string(value))
+	value, err = cache.AppendTo([]byte("prefix:"), "key1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "prefix:value1", string(value))
+	allocs := testing.AllocsPerRun(100, func() {
+		buffer, _ = cache.GetInto("key1", buffer)
+	})
+	assert.EqualValues(t, 0, allocs)
+
+	//value from secondary segment is promoted
+	idx := atomic.LoadUint32(&cache.index)
+	atomic.StoreUint32(&cache.index, cache.nextIndex(idx))
+	assert.Nil(t, cache.View("key1", func(value []byte) {
+		actual = string(value)
+	}))
+	assert.EqualValues(t, "value1", actual)
+	_, has := cache.segments[cache.nextIndex(idx)].get([]byte("key1"))
+	assert.True(t, has)
+
+	//segment reset waits for outstanding readers
+	viewing, resumed, recycled := make(chan bool), make(chan bool), make(chan bool)
+	go func() {
+		_ = cache.View("key1", func(value []byte) {
+			viewing <- true
+			<-resumed
+			actual = string(value)
+		})
+	}()
+	<-viewing
+	go func() {
+		cache.segments[cache.nextIndex(idx)].reset()
+		recycled <- true
+	}()
+	select {
+	case <-recycled:
+		t.Fatal("segment was reset while value was being viewed")
+	case <-time.After(20 * time.Millisecond):
+	}
+	resumed <- true
+	<-recycled
+	assert.EqualValues(t, "value1", actual)
+}
+
+func TestCache_Checksum(t *testing.T) {
+	for _, checksum := range []bool{true, false} {
+		cache, err := New(&Config{SizeMb: 1, Checksum: checksum})
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Nil(t, cache.Set("key1", []byte("value1")))
+		value, err := cache.Get("key1")
+		assert.Nil(t, err)
+		value[0] = 'V' //simulate data corruption
+		_, err = cache.Get("key1")
+		if checksum {
+			assert.NotNil(t, err)
+			assert.EqualValues(t, 1, cache.Stats().Corrupted)
+		} else {
+			assert.Nil(t, err)
+			assert.EqualValues(t, 0, cache.Stats().Corrupted)
+		}
+		assert.Nil(t, cache.Set("key1", []byte("value2")))
+		value, err = cache.Get("key1")
+		assert.Nil(t, err)
+		assert.EqualValues(t, "value2", string(value))
+		assert.Nil(t, cache.Close())
+	}
+}
+
+func TestCache_DeleteAfterSegmentSwitch(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	switched := 0
+	cache.OnSegmentSwitch = func(index, keys uint32, timeTaken time.Duration) {
+		switched++
+	}
+	assert.Nil(t, cache.Set("key", []byte("value")))
+	payload := []byte(strings.Repeat("x", 1024))
+	for i := 0; switched == 0; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("filler%v", i), payload))
+	}
+	secondary := &cache.segments[cache.nextIndex(atomic.LoadUint32(&cache.index))]
+	_, has := secondary.get([]byte("key"))
+	assert.True(t, has, "key should live in secondary segment after switch")
+
+	assert.Nil(t, cache.Delete("key"))
+	_, has = secondary.get([]byte("key"))
+	assert.False(t, has)
+	_, err = cache.Get("key")
+	assert.NotNil(t, err, "deleted key should not be promoted from secondary segment")
+	_, err = cache.Get("key")
+	assert.NotNil(t, err)
+
+	//key promoted to primary before delete
+	assert.Nil(t, cache.Set("key2", []byte("value2")))
+	for i := 0; switched == 1; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("filler%v", i), payload))
+	}
+	value, err := cache.Get("key2")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value2", string(value))
+	assert.Nil(t, cache.Delete("key2"))
+	for i := range cache.segments {
+		_, has = cache.segments[i].get([]byte("key2"))
+		assert.False(t, has)
+	}
+	_, err = cache.Get("key2")
+	assert.NotNil(t, err)
+}
+
+func TestCache_Stats(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	assert.Nil(t, cache.Set("key1", []byte("value1")))
+	_, _ = cache.Get("key1")
+	_, _ = cache.Get("key2")
+	assert.Nil(t, cache.Delete("key1"))
+
+	stats := cache.Stats()
+	assert.EqualValues(t, 2, stats.Gets)
+	assert.EqualValues(t, 1, stats.PrimaryHits)
+	assert.EqualValues(t, 0, stats.SecondaryHits)
+	assert.EqualValues(t, 1, stats.Misses)
+	assert.EqualValues(t, 1, stats.Sets)
+	assert.EqualValues(t, 1, stats.Deletes)
+	assert.EqualValues(t, 6, stats.BytesWritten)
+	assert.EqualValues(t, 0.5, stats.HitRatio())
+	assert.Len(t, stats.Segments, DefaultSegments)
+
+	assert.Nil(t, cache.Set("key3", []byte("value3")))
+	atomic.StoreUint32(&cache.index, cache.nextIndex(atomic.LoadUint32(&cache.index)))
+	_, err = cache.Get("key3")
+	assert.Nil(t, err)
+	stats = cache.Stats()
+	assert.EqualValues(t, 1, stats.SecondaryHits)
+	for _, segment := range stats.Segments {
+		assert.EqualValues(t, 1, segment.Keys)
+		assert.True(t, segment.Tail > 0 && segment.Tail <= segment.Size)
+		assert.EqualValues(t, segment.Index == cache.index, segment.Active)
+	}
+
+	assert.NotNil(t, cache.Set("large", make([]byte, 2*mb)))
+	stats = cache.Stats()
+	assert.EqualValues(t, 1, stats.FailedSets)
+	assert.EqualValues(t, 0, stats.Switches, "entry that never fits a segment should not switch segments")
+
+	cache.ResetStats()
+	stats = cache.Stats()
+	assert.EqualValues(t, 0, stats.Gets)
+	assert.EqualValues(t, 0, stats.Sets)
+	assert.EqualValues(t, 0, stats.FailedSets)
+	assert.EqualValues(t, 0, stats.Switches)
+}
+
+func TestCache_Range(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("key%v", i), []byte(fmt.Sprintf("old%v", i))))
+	}
+	atomic.StoreUint32(&cache.index, cache.nextIndex(atomic.LoadUint32(&cache.index)))
+	for i := 5; i < 15; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("key%v", i), []byte(fmt.Sprintf("new%v", i))))
+	}
+	assert.Nil(t, cache.Delete("key0"))
+	assert.Nil(t, cache.SetWithTTL("key1", []byte("expired"), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	actual := map[string]string{}
+	cache.Range(func(key string, value []byte) bool {
+		_, ok := actual[key]
+		assert.False(t, ok, "key reported twice: "+key)
+		actual[key] = string(value)
+		return true
+	})
+	expected := map[string]string{}
+	for i := 2; i < 15; i++ {
+		prefix := "new"
+		if i < 5 {
+			prefix = "old"
+		}
+		expected[fmt.Sprintf("key%v", i)] = fmt.Sprintf("%v%v", prefix, i)
+	}
+	assert.EqualValues(t, expected, actual)
+	_, err = cache.Get("key1")
+	assert.NotNil(t, err, "expired entry should shadow older segment value")
+
+	var keys []string
+	cache.Keys(func(key string) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	assert.Len(t, keys, 3)
+
+	//keys promoted by read once primary segment has been walked are still reported
+	reported := map[string]int{}
+	cache.Range(func(key string, value []byte) bool {
+		reported[key]++
+		if len(reported) == 10 {
+			for i := 2; i < 5; i++ {
+				_, err := cache.Get(fmt.Sprintf("key%v", i))
+				assert.Nil(t, err)
+			}
+		}
+		return true
+	})
+	assert.Len(t, reported, len(expected))
+	for key, count := range reported {
+		assert.EqualValues(t, 1, count, key)
+	}
+
+	//range callback can modify the cache
+	cache.Range(func(key string, value []byte) bool {
+		assert.Nil(t, cache.Delete(key))
+		return true
+	})
+	count := 0
+	cache.Keys(func(key string) bool {
+		count++
+		return true
+	})
+	assert.EqualValues(t, 0, count)
+}
+
+func TestCache_Generations(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 4, Segments: 4})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	assert.EqualValues(t, mb, cache.config.SegmentDataSize())
+	switched := 0
+	cache.OnSegmentSwitch = func(index, keys uint32, timeTaken time.Duration) {
+		switched++
+	}
+	payload := []byte(strings.Repeat("x", 1024))
+	assert.Nil(t, cache.Set("key", []byte("value")))
+	i := 0
+	for ; switched < 2; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("filler%v", i), payload))
+	}
+	//after two switches the key lives in the third generation and is promoted on read
+	idx := atomic.LoadUint32(&cache.index)
+	_, has := cache.segments[cache.olderIndex(idx, 2)].get([]byte("key"))
+	assert.True(t, has)
+	value, err := cache.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value", string(value))
+	_, has = cache.segments[idx].get([]byte("key"))
+	assert.True(t, has)
+	assert.EqualValues(t, 1, cache.Stats().SecondaryHits)
+
+	//only the oldest generation is dropped on switch
+	_, err = cache.Get("filler0")
+	assert.Nil(t, err)
+	for ; switched < 5; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("filler%v", i), payload))
+	}
+	_, err = cache.Get("filler1")
+	assert.NotNil(t, err)
+	for _, key := range []string{"key", "filler0", fmt.Sprintf("filler%v", i-1)} {
+		_, err = cache.Get(key)
+		assert.Nil(t, err, key)
+	}
+	assert.Nil(t, cache.Delete("key"))
+	for j := range cache.segments {
+		_, has = cache.segments[j].get([]byte("key"))
+		assert.False(t, has)
+	}
+}
+
+func TestCache_SwitchSegment(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	spare := cache.spare
+	idx := atomic.LoadUint32(&cache.index)
+	nextIndex := cache.nextIndex(idx)
+	detached := cache.segments[nextIndex].getShardedMap()
+	assert.Nil(t, cache.Set("key", []byte("value")))
+	atomic.StoreUint32(&cache.index, nextIndex)
+	assert.Nil(t, cache.Set("key", []byte("value")))
+	atomic.StoreUint32(&cache.index, idx)
+
+	cache.switchSegment(idx)
+	assert.EqualValues(t, nextIndex, atomic.LoadUint32(&cache.index))
+	assert.True(t, spare == cache.segments[nextIndex].getShardedMap(), "spare keys index should be swapped in")
+	_, has := cache.segments[nextIndex].get([]byte("key"))
+	assert.False(t, has)
+
+	//detached keys index is cleared in background and becomes spare
+	for i := 0; i < 1000; i++ {
+		cache.mutex.Lock()
+		spare = cache.spare
+		cache.mutex.Unlock()
+		if spare != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if assert.True(t, spare == detached) {
+		for _, aMap := range spare.maps {
+			assert.EqualValues(t, 0, aMap.Count())
+		}
+	}
+	value, err := cache.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value", string(value))
+}
+
+func TestCache_GetValueStability(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, cache.Set("a", []byte("value 1")))
+	assert.Nil(t, cache.Set("b", []byte("value 1")))
+	value, err := cache.Get("a")
+	assert.Nil(t, err)
+	values, err := cache.MGet([]string{"b"})
+	assert.Nil(t, err)
+	assert.Nil(t, cache.Set("a", []byte("value 2")))
+	assert.Nil(t, cache.Set("b", []byte("value 2")))
+	assert.EqualValues(t, "value 1", string(value), "returned value is not overwritten in place")
+	assert.EqualValues(t, "value 1", string(values[0]), "returned value is not overwritten in place")
+	actual, err := cache.Get("a")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value 2", string(actual))
+}
+
+func TestCache_InterleavedReadSet(t *testing.T) {
+	var useCases = []struct {
+		description string
+		read        func(cache *Cache) error
+		inPlace     bool
+	}{
+		{
+			description: "get",
+			read: func(cache *Cache) error {
+				_, err := cache.Get("key")
+				return err
+			},
+		},
+		{
+			description: "get into",
+			read: func(cache *Cache) error {
+				_, err := cache.GetInto("key", make([]byte, 0, 16))
+				return err
+			},
+			inPlace: true,
+		},
+		{
+			description: "view",
+			read: func(cache *Cache) error {
+				return cache.View("key", func(value []byte) {})
+			},
+			inPlace: true,
+		},
+	}
+
+	for _, useCase := range useCases {
+		cache, err := New(&Config{SizeMb: 1})
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		assert.Nil(t, cache.Set("key", []byte("value 0")), useCase.description)
+		primary := &cache.segments[atomic.LoadUint32(&cache.index)]
+		tail := atomic.LoadUint64(&primary.tail)
+		for i := 1; i <= 10; i++ {
+			assert.Nil(t, useCase.read(cache), useCase.description)
+			assert.Nil(t, cache.Set("key", []byte(fmt.Sprintf("value %v", i))), useCase.description)
+		}
+		actual, err := cache.GetInto("key", nil)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, "value 10", string(actual), useCase.description)
+		assert.Equal(t, useCase.inPlace, tail == atomic.LoadUint64(&primary.tail), useCase.description)
+		_ = cache.Close()
+	}
+}
+
+func TestCache_BytesKey(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	key := []byte("key1")
+	assert.Nil(t, cache.SetBytes(key, []byte("value1")))
+	value, err := cache.GetBytes(key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value1", string(value))
+	value, err = cache.Get("key1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value1", string(value))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = cache.SetBytes(key, value)
+		_, _ = cache.GetBytes(key)
+	})
+	assert.EqualValues(t, 0, allocs)
+
+	assert.Nil(t, cache.DeleteBytes(key))
+	_, err = cache.GetBytes(key)
+	assert.NotNil(t, err)
+	_, err = cache.Get("key1")
+	assert.NotNil(t, err)
+}
+
+func TestCache_Errors(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, Checksum: true, MaxEntries: 10})
+	if !assert.Nil(t, err) {
+		return
+	}
+	err = cache.Set("large", make([]byte, mb))
+	assert.True(t, errors.Is(err, ErrEntryTooLarge), err)
+	err = cache.Set(strings.Repeat("k", maxKeySize+1), nil)
+	assert.True(t, errors.Is(err, ErrEntryTooLarge), err)
+	_, err = cache.Incr(strings.Repeat("k", maxKeySize+1), 1)
+	assert.True(t, errors.Is(err, ErrEntryTooLarge), err)
+
+	primary := &cache.segments[cache.index]
+	primary.keys = 10
+	err = cache.setError(primary, []byte("key"))
+	assert.True(t, errors.Is(err, ErrMaxEntries), err)
+	primary.keys = 0
+
+	assert.Nil(t, cache.Set("key1", []byte("value1")))
+	value, err := cache.Get("key1")
+	assert.Nil(t, err)
+	value[0] = 'V' //corrupt entry data
+	_, err = cache.Get("key1")
+	assert.True(t, errors.Is(err, ErrCorrupted), err)
+	assert.True(t, errors.Is(cache.View("key1", func(value []byte) {}), ErrCorrupted))
+	_, err = cache.Get("key2")
+	assert.True(t, isNoSuchKey(err), err)
+
+	assert.Nil(t, cache.Close())
+	assert.True(t, errors.Is(cache.Close(), ErrClosed))
+	assert.True(t, errors.Is(cache.Set("key1", nil), ErrClosed))
+	_, err = cache.Get("key1")
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.True(t, errors.Is(cache.Delete("key1"), ErrClosed))
+	assert.True(t, errors.Is(cache.View("key1", func(value []byte) {}), ErrClosed))
+	_, err = cache.MGet([]string{"key1"})
+	assert.True(t, errors.Is(err, ErrClosed))
+	_, err = cache.Incr("key1", 1)
+	assert.True(t, errors.Is(err, ErrClosed))
+	_, err = cache.CompareAndSet("key1", 0, nil)
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.True(t, errors.Is(cache.Range(func(key string, value []byte) bool { return true }), ErrClosed))
+}
+
+func TestCache_CloseLifecycle(t *testing.T) {
+	location := t.TempDir() + "/scache.mmap"
+	for i := 0; i < 3; i++ {
+		cache, err := New(&Config{SizeMb: 1, Location: location})
+		if !assert.Nil(t, err) {
+			return
+		}
+		key := fmt.Sprintf("key%v", i)
+		assert.Nil(t, cache.Set(key, []byte("value")))
+		assert.Nil(t, cache.Sync())
+		for j := 0; j < i; j++ { //previous open values are restored after reopen
+			value, err := cache.GetInto(fmt.Sprintf("key%v", j), nil)
+			assert.Nil(t, err)
+			assert.EqualValues(t, "value", string(value))
+		}
+		for j := range cache.segments {
+			assert.EqualValues(t, 1, len(cache.segments[j].mmap.regions))
+		}
+		assert.Nil(t, cache.Close())
+		for j := range cache.segments {
+			assert.Nil(t, cache.segments[j].mmap.regions, "segment data should be unmapped")
+		}
+		assert.True(t, errors.Is(cache.Sync(), ErrClosed))
+		assert.True(t, errors.Is(cache.Close(), ErrClosed))
+	}
+
+	//operations concurrent with close fail cleanly
+	cache, err := New(&Config{SizeMb: 1, Location: location})
+	if !assert.Nil(t, err) {
+		return
+	}
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			buffer := make([]byte, 0, 32)
+			for j := 0; ; j++ {
+				key := fmt.Sprintf("key%v", j%100)
+				err := cache.Set(key, []byte(key))
+				if err == nil {
+					buffer, err = cache.GetInto(key, buffer)
+				}
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, cache.Close())
+	waitGroup.Wait()
+}
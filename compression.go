@@ -0,0 +1,197 @@
+package scache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+const (
+	//CompressionFlate DEFLATE value compression
+	CompressionFlate = "flate"
+	//CompressionGzip gzip value compression
+	CompressionGzip = "gzip"
+	//DefaultCompressionThreshold default value size above which values are compressed
+	DefaultCompressionThreshold = 512
+)
+
+// compression identifiers stored in memory mapped file header
+const (
+	noCompressionID = iota
+	customCompressionID
+	flateCompressionID
+	gzipCompressionID
+)
+
+// Compressor represents value compressor, implementation has to be safe for concurrent use
+type Compressor interface {
+	//Compress appends compressed src to dst
+	Compress(dst, src []byte) ([]byte, error)
+	//Decompress appends decompressed src to dst
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+type flateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// Compress appends DEFLATE compressed src to dst
+func (c *flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(dst)
+	writer, _ := c.writers.Get().(*flate.Writer)
+	if writer == nil {
+		var err error
+		if writer, err = flate.NewWriter(buffer, c.level); err != nil {
+			return nil, err
+		}
+	} else {
+		writer.Reset(buffer)
+	}
+	defer c.writers.Put(writer)
+	if _, err := writer.Write(src); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decompress appends DEFLATE decompressed src to dst
+func (c *flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	reader, _ := c.readers.Get().(io.ReadCloser)
+	if reader == nil {
+		reader = flate.NewReader(bytes.NewReader(src))
+	} else if err := reader.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+		return nil, err
+	}
+	defer c.readers.Put(reader)
+	buffer := bytes.NewBuffer(dst)
+	if _, err := buffer.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// NewFlateCompressor creates DEFLATE compressor with the supplied compression level
+func NewFlateCompressor(level int) Compressor {
+	return &flateCompressor{level: level}
+}
+
+type gzipCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// Compress appends gzip compressed src to dst
+func (c *gzipCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buffer := bytes.NewBuffer(dst)
+	writer, _ := c.writers.Get().(*gzip.Writer)
+	if writer == nil {
+		var err error
+		if writer, err = gzip.NewWriterLevel(buffer, c.level); err != nil {
+			return nil, err
+		}
+	} else {
+		writer.Reset(buffer)
+	}
+	defer c.writers.Put(writer)
+	if _, err := writer.Write(src); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decompress appends gzip decompressed src to dst
+func (c *gzipCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	reader, _ := c.readers.Get().(*gzip.Reader)
+	var err error
+	if reader == nil {
+		reader, err = gzip.NewReader(bytes.NewReader(src))
+	} else {
+		err = reader.Reset(bytes.NewReader(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer c.readers.Put(reader)
+	buffer := bytes.NewBuffer(dst)
+	if _, err := buffer.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// NewGzipCompressor creates gzip compressor with the supplied compression level
+func NewGzipCompressor(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+// compressionID returns configured compression identifier persisted with memory mapped file
+func (c *Config) compressionID() uint32 {
+	if c.Compressor != nil {
+		return customCompressionID
+	}
+	switch c.Compression {
+	case CompressionFlate:
+		return flateCompressionID
+	case CompressionGzip:
+		return gzipCompressionID
+	}
+	return noCompressionID
+}
+
+// newCompressor creates configured value compressor, it returns nil if compression is disabled
+func (c *Config) newCompressor() (Compressor, error) {
+	if c.Compressor != nil {
+		return c.Compressor, nil
+	}
+	switch c.Compression {
+	case "":
+		return nil, nil
+	case CompressionFlate:
+		return NewFlateCompressor(flate.DefaultCompression), nil
+	case CompressionGzip:
+		return NewGzipCompressor(gzip.DefaultCompression), nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %v", c.Compression)
+}
+
+// compress returns compressed value flagged with compressedEntry if compression is enabled, value exceeds
+// compression threshold and its compressed form is smaller, otherwise the original value is returned
+func (s *Cache) compress(value []byte) ([]byte, byte) {
+	compressor := s.config.compressor
+	if compressor == nil || len(value) <= s.config.CompressionThreshold {
+		return value, 0
+	}
+	compressed, err := compressor.Compress(make([]byte, 0, len(value)/2), value)
+	if err != nil || len(compressed) >= len(value) {
+		return value, 0
+	}
+	return compressed, compressedEntry
+}
+
+// decompress returns decompressed value of entry flagged with compressedEntry, other values are returned as is
+func (s *Cache) decompress(value []byte, flags byte) ([]byte, error) {
+	if flags&compressedEntry == 0 {
+		return value, nil
+	}
+	if s.config.compressor == nil {
+		return nil, ErrCorrupted
+	}
+	decompressed, err := s.config.compressor.Decompress(make([]byte, 0, 4*len(value)), value)
+	if err != nil {
+		return nil, errors.Wrapf(ErrCorrupted, "failed to decompress value: %v", err)
+	}
+	return decompressed, nil
+}
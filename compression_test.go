@@ -0,0 +1,123 @@
+package scache
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCache_Compression(t *testing.T) {
+	random := make([]byte, 2048)
+	rand.New(rand.NewSource(1)).Read(random)
+	var useCases = []struct {
+		description string
+		config      *Config
+		value       []byte
+		compressed  bool
+	}{
+		{
+			description: "flate",
+			config:      &Config{SizeMb: 1, Compression: CompressionFlate},
+			value:       []byte(strings.Repeat(`{"id":1,"name":"abc"}`, 100)),
+			compressed:  true,
+		},
+		{
+			description: "gzip",
+			config:      &Config{SizeMb: 1, Compression: CompressionGzip},
+			value:       []byte(strings.Repeat(`{"id":1,"name":"abc"}`, 100)),
+			compressed:  true,
+		},
+		{
+			description: "custom compressor",
+			config:      &Config{SizeMb: 1, Compressor: NewFlateCompressor(1)},
+			value:       []byte(strings.Repeat("abc", 1000)),
+			compressed:  true,
+		},
+		{
+			description: "value below threshold",
+			config:      &Config{SizeMb: 1, Compression: CompressionFlate, CompressionThreshold: 4096},
+			value:       []byte(strings.Repeat("abc", 1000)),
+		},
+		{
+			description: "incompressible value",
+			config:      &Config{SizeMb: 1, Compression: CompressionGzip},
+			value:       random,
+		},
+	}
+
+	for _, useCase := range useCases {
+		cache, err := New(useCase.config)
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		assert.Nil(t, cache.Set("key", useCase.value), useCase.description)
+		headerAddress, _, _ := cache.segments[cache.index].lookup([]byte("key"))
+		assert.Equal(t, useCase.compressed, cache.segments[cache.index].flags(headerAddress)&compressedEntry != 0, useCase.description)
+		if useCase.compressed {
+			assert.True(t, cache.Stats().BytesWritten < uint64(len(useCase.value)), useCase.description)
+		}
+
+		actual, err := cache.Get("key")
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, useCase.value, actual, useCase.description)
+		assert.Nil(t, cache.View("key", func(actual []byte) {
+			assert.EqualValues(t, useCase.value, actual, useCase.description)
+		}), useCase.description)
+
+		assert.Nil(t, cache.MSet([]string{"key1", "key2"}, [][]byte{useCase.value, []byte("small")}), useCase.description)
+		values, err := cache.MGet([]string{"key1", "key2"})
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, useCase.value, values[0], useCase.description)
+		assert.EqualValues(t, "small", string(values[1]), useCase.description)
+
+		_, version, err := cache.GetWithVersion("key")
+		assert.Nil(t, err, useCase.description)
+		_, err = cache.CompareAndSet("key", version, useCase.value[1:])
+		assert.Nil(t, err, useCase.description)
+
+		cache.switchSegment(cache.index) //older generation entry is promoted as is
+		actual, err = cache.Get("key")
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, useCase.value[1:], actual, useCase.description)
+
+		found := 0
+		assert.Nil(t, cache.Range(func(key string, actual []byte) bool {
+			if key == "key1" {
+				assert.EqualValues(t, useCase.value, actual, useCase.description)
+			}
+			found++
+			return true
+		}), useCase.description)
+		assert.EqualValues(t, 3, found, useCase.description)
+	}
+
+	_, err := New(&Config{Compression: "lz4"})
+	assert.NotNil(t, err)
+}
+
+func TestCache_CompressionPersistence(t *testing.T) {
+	location := t.TempDir() + "/scache.mmap"
+	value := []byte(strings.Repeat("value", 200))
+	cache, err := New(&Config{SizeMb: 2, Location: location, Compression: CompressionFlate})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, cache.Set("key", value))
+	assert.Nil(t, cache.Close())
+
+	_, err = New(&Config{SizeMb: 2, Location: location})
+	mismatch := &LayoutMismatch{}
+	if assert.True(t, errors.As(err, &mismatch)) {
+		assert.EqualValues(t, "Compression", mismatch.Field)
+	}
+	cache, err = New(&Config{SizeMb: 2, Location: location, Compression: CompressionFlate})
+	if !assert.Nil(t, err) {
+		return
+	}
+	actual, err := cache.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, value, actual)
+	assert.Nil(t, cache.Close())
+}
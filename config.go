@@ -1,5 +1,11 @@
 package scache
 
+import (
+	"fmt"
+	"math/bits"
+	"time"
+)
+
 const (
 	//DefaultCacheSizeMb default cache size
 	DefaultCacheSizeMb = 1
@@ -7,24 +13,51 @@ const (
 	MinShards = 32
 	//DefaultShardMapSize default map shard allocation size.
 	DefaultShardMapSize = 32 * 1024
-	mb                  = 1024 * 1024
-	alignmentSize       = 32
+	//DefaultKeySize default key size used to estimate SizeMb
+	DefaultKeySize = 16
+	//DefaultSegments default number of segments
+	DefaultSegments = 2
+	//DefaultAlignment default entry data alignment
+	DefaultAlignment = 32
+	mb               = 1024 * 1024
+	//maxAddress entries are addressed with uint32 aligned offset
+	maxAddress = 1 << 32
+	//segmentSizeAlignment keeps memory mapped segments offset page aligned
+	segmentSizeAlignment = 64 * 1024
+	//minSegmentSize min segment data size
+	minSegmentSize = segmentSizeAlignment
 )
 
 //Config represents cache config
 type Config struct {
-	MaxEntries   int //optional upper entries limit in the cache
-	EntrySize    int //optional entry size to estimate SizeMb (MaxEntries * EntrySize) when specified
-	KeySize      int
-	SizeMb       int    //optional max cache size, default 1
-	Shards       uint64 //optional segment shards size,  default MAX(32, MaxEntries / 1024*1024)
-	Location     string //optional path to mapped memory file
-	shardMapSize int
+	MaxEntries             int           //optional upper entries limit in the cache
+	EntrySize              int           //optional entry size to estimate SizeMb (MaxEntries * EntrySize) when specified
+	KeySize                int           //optional average key size to estimate SizeMb
+	SizeMb                 int           //optional max cache size, default 1
+	Shards                 uint64        //optional segment shards size,  default MAX(32, MaxEntries / 1024*1024)
+	Location               string        //optional path to mapped memory file
+	Segments               int           //optional number of segments rotating as a ring, default 2
+	DefaultTTL             time.Duration //optional entry time to live used by Set, zero means entries do not expire
+	ReinitializeOnMismatch bool          //optional flag to reinitialize mapped memory file with layout not matching config, otherwise New returns LayoutMismatch
+	Checksum               bool          //optional flag to store entry CRC32C checksum verified on read, reading corrupted entry returns ErrCorrupted
+	Hash                   string        //optional key hash: maphash (memory cache default), xxhash (memory mapped file default) or fnv
+	HashSeed               uint64        //optional xxhash seed, random by default, memory mapped file persists the seed in its header
+	Hasher                 Hasher        //optional custom key hasher, takes precedence over Hash
+	Alignment              int           //optional entry data alignment: 8, 16, 32 or 64 bytes, default 32, smaller alignment wastes less space but limits max segment size
+	LargeSizeMb            int           //optional large object area size storing values not fitting a segment, default 0 disables large values
+	LargeValueSize         int           //optional value size above which values are stored in the large object area, by default only values not fitting a segment
+	Compression            string        //optional value compression: flate or gzip, large values are not compressed
+	Compressor             Compressor    //optional custom value compressor, takes precedence over Compression
+	CompressionThreshold   int           //optional value size above which values are compressed, default 512, min 8
+	shardMapSize           int
+	alignmentShift         uint
+	hasher                 Hasher
+	compressor             Compressor
 }
 
-//SegmentDataSize returns segments data size (cache always has 2 segments)
+//SegmentDataSize returns segments data size
 func (c *Config) SegmentDataSize() int {
-	return c.SizeMb * (mb / 2)
+	return (c.SizeMb * mb / c.Segments) &^ (segmentSizeAlignment - 1)
 }
 
 //Init initialises config
@@ -32,9 +65,19 @@ func (c *Config) Init() {
 	if c.SizeMb == 0 {
 		c.SizeMb = DefaultCacheSizeMb
 	}
+	if c.Alignment == 0 {
+		c.Alignment = DefaultAlignment
+	}
+	c.alignmentShift = uint(bits.TrailingZeros(uint(c.Alignment)))
 
+	if c.KeySize == 0 {
+		c.KeySize = DefaultKeySize
+	}
+	if c.Segments < DefaultSegments {
+		c.Segments = DefaultSegments
+	}
 	if c.MaxEntries > 0 && c.EntrySize > 0 {
-		estSizeMb := DefaultCacheSizeMb + (2*c.MaxEntries*alignSize(headerSize+c.EntrySize))/mb
+		estSizeMb := DefaultCacheSizeMb + (c.Segments*c.MaxEntries*c.alignSize(headerSize+c.KeySize+c.EntrySize))/mb
 		if c.SizeMb < estSizeMb {
 			c.SizeMb = estSizeMb
 		}
@@ -51,9 +94,35 @@ func (c *Config) Init() {
 	} else {
 		c.shardMapSize = DefaultShardMapSize
 	}
+	if c.CompressionThreshold == 0 {
+		c.CompressionThreshold = DefaultCompressionThreshold
+	} else if c.CompressionThreshold < counterSize { //counter sized values are not compressed, so that they can be incremented
+		c.CompressionThreshold = counterSize
+	}
+	if c.Hash == "" {
+		c.Hash = HashMaphash
+		if c.Location != "" {
+			c.Hash = HashXX
+		}
+	}
+
+}
 
+// alignSize rounds size up to the entry data alignment
+func (c *Config) alignSize(size int) int {
+	return (size + c.Alignment - 1) &^ (c.Alignment - 1)
 }
 
-func alignSize(size int) int {
-	return ((size >> 5) + 1) << 5
+// maxSupportedSize returns max addressable segment data size for the entry data alignment
+func (c *Config) maxSupportedSize() uint64 {
+	return maxAddress << c.alignmentShift
+}
+
+// validateAlignment checks if entry data alignment is supported
+func (c *Config) validateAlignment() error {
+	switch c.Alignment {
+	case 8, 16, 32, 64:
+		return nil
+	}
+	return fmt.Errorf("unsupported alignment: %v, supported: 8, 16, 32, 64", c.Alignment)
 }
@@ -0,0 +1,132 @@
+package scache
+
+import (
+	"github.com/pkg/errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+const counterSize = 8
+
+// Incr atomically adds delta to the key counter and returns its new value, missing key counter starts with zero,
+// counter is stored as 8 bytes int64 in native byte order and updated in place while it stays in the primary segment
+func (s *Cache) Incr(key string, delta int64) (int64, error) {
+	return s.incr(keyBytes(key), delta)
+}
+
+// Decr atomically subtracts delta from the key counter and returns its new value
+func (s *Cache) Decr(key string, delta int64) (int64, error) {
+	return s.incr(keyBytes(key), -delta)
+}
+
+// incr updates counter in place when it is stored in the primary segment, otherwise counter entry is created
+// in the primary segment under the key shard lock, initialised with the value from older generation if any,
+// the attempt is retried if segments switched in the meantime
+func (s *Cache) incr(key []byte, delta int64) (int64, error) {
+	if !s.enter() {
+		return 0, ErrClosed
+	}
+	defer s.exit()
+	if err := s.checkEntry(key, counterSize, counterEntry); err != nil {
+		return 0, err
+	}
+	expiry := expiryTime(s.config.DefaultTTL)
+	value := make([]byte, counterSize)
+	for attempt := 0; attempt < 2; {
+		idx := atomic.LoadUint32(&s.index)
+		primary := &s.segments[idx]
+		if result, ok := s.incrInPlace(idx, primary, key, delta); ok {
+			atomic.AddUint64(&s.counters.sets, 1)
+			return result, nil
+		}
+		base, err := s.olderCounter(idx, key)
+		if err != nil {
+			return 0, err
+		}
+		var result int64
+		stale := false
+		accepted, ok := primary.setIf(key, value, expiry, counterEntry, func(headerAddress uint64) (uint64, bool) {
+			//counter of segment switched out after older generations were read may have been copied to the new primary
+			if stale = atomic.LoadUint32(&s.index) != idx; stale {
+				return 0, false
+			}
+			if headerAddress != 0 { //primary segment entry shadows older generations
+				if base, err = entryCounter(primary, headerAddress); err != nil {
+					return 0, false
+				}
+				if primary.flags(headerAddress)&counterEntry != 0 { //counter was created concurrently
+					result = atomic.AddInt64(primary.counter(headerAddress), delta)
+					return 0, false
+				}
+			}
+			result = base + delta
+			*(*int64)(unsafe.Pointer(&value[0])) = result
+			return s.nextVersion(1), true
+		})
+		if err != nil {
+			return 0, err
+		}
+		if stale {
+			continue
+		}
+		if !accepted || ok {
+			atomic.AddUint64(&s.counters.sets, 1)
+			return result, nil
+		}
+		s.switchSegment(idx)
+		attempt++
+	}
+	return 0, s.setError(&s.segments[atomic.LoadUint32(&s.index)], key)
+}
+
+// incrInPlace atomically adds delta to the counter stored in the primary segment, the key shard read lock is held while
+// primary segment index is verified and counter updated, so that counter copied by incr after segment switch includes the update
+func (s *Cache) incrInPlace(idx uint32, primary *segment, key []byte, delta int64) (result int64, ok bool) {
+	primary.getShardedMap().read(key, primary, func(headerAddress uint64) {
+		if headerAddress == 0 || atomic.LoadUint32(&s.index) != idx {
+			return
+		}
+		if _, _, has := primary.entry(headerAddress); !has || primary.flags(headerAddress)&counterEntry == 0 {
+			return
+		}
+		result, ok = atomic.AddInt64(primary.counter(headerAddress), delta), true
+	})
+	return result, ok
+}
+
+// olderCounter returns the key counter value stored in older generations than the supplied primary segment,
+// the key shard write lock is held while reading to wait for in place updates started before segment switch
+func (s *Cache) olderCounter(idx uint32, key []byte) (result int64, err error) {
+	for generation := uint32(1); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		found := false
+		segment.getShardedMap().update(key, segment, func(headerAddress uint64) uint32 {
+			if found = headerAddress != 0; found {
+				result, err = entryCounter(segment, headerAddress)
+			}
+			return 0
+		})
+		if found {
+			return result, err
+		}
+	}
+	return 0, nil
+}
+
+// entryCounter returns counter value of the entry stored at the supplied header address, zero for expired or negative entry,
+// plain entry is accepted as counter if it stores 8 bytes value
+func entryCounter(segment *segment, headerAddress uint64) (int64, error) {
+	_, value, has := segment.entry(headerAddress)
+	flags := segment.flags(headerAddress)
+	switch {
+	case !has || flags&negativeEntry != 0:
+		return 0, nil
+	case flags&counterEntry != 0:
+		return atomic.LoadInt64(segment.counter(headerAddress)), nil
+	case len(value) != counterSize:
+		return 0, errors.Errorf("key value is not a counter, size: %v", len(value))
+	}
+	var result int64
+	copy((*[counterSize]byte)(unsafe.Pointer(&result))[:], value)
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package scache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+func TestCache_Incr(t *testing.T) {
+	var useCases = []struct {
+		description string
+		config      *Config
+		key         string
+	}{
+		{
+			description: "memory cache",
+			config:      &Config{SizeMb: 1},
+			key:         "counter",
+		},
+		{
+			description: "unaligned key with checksum",
+			config:      &Config{SizeMb: 1, Checksum: true, Segments: 3},
+			key:         "user:123",
+		},
+		{
+			description: "compression with negative threshold",
+			config:      &Config{SizeMb: 1, Compressor: zeroCompressor{}, CompressionThreshold: -1},
+			key:         "counter",
+		},
+	}
+
+	for _, useCase := range useCases {
+		cache, err := New(useCase.config)
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		value, err := cache.Incr(useCase.key, 5)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 5, value, useCase.description)
+		value, err = cache.Decr(useCase.key, 2)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 3, value, useCase.description)
+		tail := cache.segments[cache.index].tail
+		value, err = cache.Incr(useCase.key, 1)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 4, value, useCase.description)
+		assert.EqualValues(t, tail, cache.segments[cache.index].tail, "counter should be updated in place: "+useCase.description)
+
+		data, err := cache.Get(useCase.key)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 4, *(*int64)(unsafe.Pointer(&data[0])), useCase.description)
+
+		//counter in older generation is promoted
+		cache.switchSegment(cache.index)
+		value, err = cache.Incr(useCase.key, 10)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 14, value, useCase.description)
+		cache.switchSegment(cache.index)
+		data, err = cache.Get(useCase.key)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 14, *(*int64)(unsafe.Pointer(&data[0])), useCase.description)
+		value, err = cache.Incr(useCase.key, 1)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 15, value, useCase.description)
+
+		assert.Nil(t, cache.Set("text", []byte("abc")), useCase.description)
+		_, err = cache.Incr("text", 1)
+		assert.NotNil(t, err, useCase.description)
+		assert.Nil(t, cache.Set("number", make([]byte, counterSize)), useCase.description)
+		value, err = cache.Incr("number", 1)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, 1, value, useCase.description)
+		_ = cache.Close()
+	}
+}
+
+//zeroCompressor compresses zero filled value to its length
+type zeroCompressor struct{}
+
+func (c zeroCompressor) Compress(dst, src []byte) ([]byte, error) {
+	for _, b := range src {
+		if b != 0 {
+			return append(dst, src...), nil
+		}
+	}
+	return append(dst, byte(len(src))), nil
+}
+
+func (c zeroCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return append(dst, make([]byte, src[0])...), nil
+}
+
+func TestCache_IncrConcurrency(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	workers, increments := 8, 5000
+	var waitGroup sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			for j := 0; j < increments; j++ {
+				_, err := cache.Incr("counter", 1)
+				assert.Nil(t, err)
+				if i == 0 && j%1000 == 0 {
+					cache.switchSegment(atomic.LoadUint32(&cache.index))
+				}
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+	value, err := cache.Incr("counter", 0)
+	assert.Nil(t, err)
+	assert.EqualValues(t, workers*increments, value)
+}
@@ -1,7 +1,23 @@
 package scache
 
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
 var noSuchKeyErr = &NoSuchKey{}
 
+var (
+	//ErrEntryTooLarge represents error of entry that never fits a segment or key exceeding max key size
+	ErrEntryTooLarge = errors.New("entry too large")
+	//ErrMaxEntries represents error of set failing due to Config.MaxEntries limit
+	ErrMaxEntries = errors.New("max entries exceeded")
+	//ErrClosed represents error returned by operations on closed cache
+	ErrClosed = errors.New("cache closed")
+	//ErrCorrupted represents error of entry failing checksum verification
+	ErrCorrupted = errors.New("entry corrupted")
+)
+
 //NoSuchKey represents no such key error
 type NoSuchKey struct{}
 
@@ -9,3 +25,27 @@ type NoSuchKey struct{}
 func (e NoSuchKey) Error() string {
 	return "key not found"
 }
+
+//LayoutMismatch represents memory mapped file layout mismatch error
+type LayoutMismatch struct {
+	Location string
+	Field    string
+	Expected uint64
+	Actual   uint64
+}
+
+//Error returns layout mismatch error
+func (e *LayoutMismatch) Error() string {
+	return fmt.Sprintf("incompatible cache file %v: %v expected: %v, but had: %v", e.Location, e.Field, e.Expected, e.Actual)
+}
+
+//VersionMismatch represents compare and set entry version mismatch error
+type VersionMismatch struct {
+	Expected uint64
+	Actual   uint64
+}
+
+//Error returns version mismatch error
+func (e *VersionMismatch) Error() string {
+	return fmt.Sprintf("version mismatch, expected: %v, but had: %v", e.Expected, e.Actual)
+}
@@ -0,0 +1,114 @@
+package scache
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// notifyEvict calls OnEvict listener with the freshest live value of the key about to be removed
+func (s *Cache) notifyEvict(key []byte, reason EvictReason) {
+	if value, has := s.evicted(key); has {
+		s.OnEvict(string(key), value, reason)
+	}
+}
+
+// evicted returns copy of the freshest live value of the key, so that the listener can be notified once the key is replaced
+func (s *Cache) evicted(key []byte) ([]byte, bool) {
+	idx := atomic.LoadUint32(&s.index)
+	for generation := uint32(0); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		if !segment.acquire() {
+			continue
+		}
+		var value []byte
+		var has bool
+		headerAddress, _, _ := segment.leaseEntry(key)
+		if headerAddress != 0 {
+			value, has = s.evictedValue(segment, key, headerAddress)
+			segment.releaseEntry(headerAddress)
+		}
+		segment.release()
+		if headerAddress != 0 { //newer entry shadows older generations
+			return value, has
+		}
+	}
+	return nil, false
+}
+
+// evictedValue returns copy of the live value stored at the supplied header address, caller prevents segment data reuse
+// and in place overwrite
+func (s *Cache) evictedValue(segment *segment, key []byte, headerAddress uint64) ([]byte, bool) {
+	_, value, has := segment.entry(headerAddress)
+	flags := segment.flags(headerAddress)
+	if !has || flags&negativeEntry != 0 {
+		return nil, false
+	}
+	if flags&(largeEntry|compressedEntry) == 0 {
+		evicted := make([]byte, len(value))
+		copy(evicted, value)
+		return evicted, true
+	}
+	return s.entryValue(key, value, flags)
+}
+
+// notifyLargeEvict calls OnEvict listener with the value evicted from the large object area if the key still points to it,
+// value of the key which pointer was recycled with its segment has been already notified
+func (s *Cache) notifyLargeEvict(key string, id uint64, value []byte) {
+	fn := s.OnEvict
+	if fn == nil {
+		return
+	}
+	idx := atomic.LoadUint32(&s.index)
+	for generation := uint32(0); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		if !segment.acquire() {
+			continue
+		}
+		headerAddress, pointer, has := segment.lookup(keyBytes(key))
+		current := has && segment.flags(headerAddress)&largeEntry != 0 && len(pointer) == largePointerSize &&
+			binary.LittleEndian.Uint64(pointer[:8]) == id
+		segment.release()
+		if headerAddress != 0 {
+			if current {
+				fn(key, value, EvictRecycle)
+			}
+			return
+		}
+	}
+}
+
+// evictSegment calls OnEvict listener for entries of the detached keys index of the segment being recycled, which are
+// not present in newer segments, segment data is not reused until the eviction completes
+func (s *Cache) evictSegment(fn OnEvict, segment *segment, keys *shardedMap, newer []*segment) {
+	now := uint64(time.Now().UnixNano())
+	for i := range keys.maps {
+		keys.lock[i].RLock()
+		keys.maps[i].Iter(func(_ uint64, address uint32) bool {
+			if address == 0 {
+				return false
+			}
+			headerAddress := uint64(address) << segment.config.alignmentShift
+			key, value, has := segment.storedEntry(headerAddress)
+			flags := segment.flags(headerAddress)
+			if !has || flags&negativeEntry != 0 {
+				return false
+			}
+			for _, candidate := range newer { //promoted or updated key
+				if candidateAddress, _, _ := candidate.lookup(key); candidateAddress != 0 {
+					return false
+				}
+			}
+			if value, has = s.entryValue(key, value, flags); !has {
+				return false
+			}
+			reason := EvictRecycle
+			if expiry := segment.expiry(headerAddress); expiry != 0 && expiry <= now {
+				reason = EvictExpired
+			}
+			fn(string(key), value, reason)
+			return false
+		})
+		keys.lock[i].RUnlock()
+	}
+}
@@ -0,0 +1,198 @@
+package scache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_OnEvict(t *testing.T) {
+	var useCases = []struct {
+		description string
+		config      *Config
+		run         func(cache *Cache)
+		expect      map[string]string
+	}{
+		{
+			description: "segment recycle",
+			config:      &Config{SizeMb: 1},
+			run: func(cache *Cache) {
+				_ = cache.Set("a", []byte("value a"))
+				_ = cache.Set("b", []byte("value b"))
+				_ = cache.Set("c", []byte("value c"))
+				cache.switchSegment(cache.index)
+				_, _ = cache.Get("a") //promoted entry is not evicted
+				_ = cache.Set("b", []byte("value b2"))
+				cache.switchSegment(cache.index)
+			},
+			expect: map[string]string{"b": "value b/overwrite", "c": "value c/recycle"},
+		},
+		{
+			description: "expired entry",
+			config:      &Config{SizeMb: 1},
+			run: func(cache *Cache) {
+				_ = cache.SetWithTTL("a", []byte("value a"), time.Millisecond)
+				time.Sleep(2 * time.Millisecond)
+				cache.switchSegment(cache.index)
+				cache.switchSegment(cache.index)
+			},
+			expect: map[string]string{"a": "value a/expired"},
+		},
+		{
+			description: "delete",
+			config:      &Config{SizeMb: 1},
+			run: func(cache *Cache) {
+				_ = cache.Set("a", []byte("value a"))
+				_ = cache.Delete("a")
+				_ = cache.Delete("b")
+				_ = cache.Set("c", []byte("value c"))
+				_ = cache.MDelete([]string{"c"})
+			},
+			expect: map[string]string{"a": "value a/delete", "c": "value c/delete"},
+		},
+		{
+			description: "overwrite",
+			config:      &Config{SizeMb: 1},
+			run: func(cache *Cache) {
+				_ = cache.Set("a", []byte("value a"))
+				_ = cache.Set("a", []byte("value a2"))
+				version, _ := cache.CompareAndSet("b", 0, []byte("value b"))
+				_, _ = cache.CompareAndSet("b", version+1, []byte("rejected"))
+				_, _ = cache.CompareAndSet("b", version, []byte("value b2"))
+			},
+			expect: map[string]string{"a": "value a/overwrite", "b": "value b/overwrite"},
+		},
+		{
+			description: "compressed entry",
+			config:      &Config{SizeMb: 1, Compression: CompressionFlate, CompressionThreshold: 8},
+			run: func(cache *Cache) {
+				_ = cache.Set("a", []byte("value value value value"))
+				_ = cache.MSet([]string{"a"}, [][]byte{[]byte("value a2")})
+			},
+			expect: map[string]string{"a": "value value value value/overwrite"},
+		},
+		{
+			description: "failed overwrite",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 1},
+			run: func(cache *Cache) {
+				_ = cache.Set("a", []byte("value a"))
+				version, _ := cache.CompareAndSet("b", 0, []byte("value b"))
+				_ = cache.Set("a", make([]byte, 2*mb))
+				_, _ = cache.CompareAndSet("b", version, make([]byte, 2*mb))
+			},
+			expect: map[string]string{},
+		},
+		{
+			description: "batch overwrite not fitting segment",
+			config:      &Config{SizeMb: 1},
+			run: func(cache *Cache) {
+				_ = cache.Set("a", []byte("value a"))
+				_ = cache.MSet([]string{"a", "b"}, [][]byte{make([]byte, 300*1024), make([]byte, 300*1024)})
+			},
+			expect: map[string]string{"a": "value a/overwrite"},
+		},
+		{
+			description: "large object area eviction",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 1, LargeValueSize: 1024},
+			run: func(cache *Cache) {
+				for _, key := range []string{"a", "b", "c"} {
+					_ = cache.Set(key, []byte(strings.Repeat(key, 400*1024)))
+				}
+			},
+			expect: map[string]string{"a": strings.Repeat("a", 400*1024) + "/recycle"},
+		},
+	}
+
+	for _, useCase := range useCases {
+		cache, err := New(useCase.config)
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		actual := map[string]string{}
+		duplicates := 0
+		cache.OnEvict = func(key string, value []byte, reason EvictReason) {
+			notified := string(value) + "/" + reason.String()
+			if actual[key] == notified {
+				duplicates++
+			}
+			actual[key] = notified
+		}
+		useCase.run(cache)
+		assert.EqualValues(t, useCase.expect, actual, useCase.description)
+		assert.EqualValues(t, 0, duplicates, useCase.description)
+	}
+}
+
+func TestCache_OnEvictSwitch(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	started, release := make(chan bool), make(chan bool)
+	cache.OnEvict = func(key string, value []byte, reason EvictReason) {
+		close(started)
+		<-release
+	}
+	_ = cache.Set("a", []byte("value a"))
+	cache.switchSegment(cache.index)
+	idx := atomic.LoadUint32(&cache.index)
+	switches := cache.Stats().Switches
+	done := make(chan bool, 2)
+	go func() {
+		cache.switchSegment(idx)
+		done <- true
+	}()
+	<-started
+	if assert.True(t, cache.mutex.TryLock(), "listener runs without the switch lock") {
+		cache.mutex.Unlock()
+	}
+	go func() {
+		cache.switchSegment(idx) //concurrent switch waits for the eviction
+		done <- true
+	}()
+	select {
+	case <-done:
+		assert.Fail(t, "segment data is reused before the eviction completes")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	<-done
+	assert.EqualValues(t, switches+1, cache.Stats().Switches)
+	assert.NotEqual(t, idx, atomic.LoadUint32(&cache.index))
+}
+
+func BenchmarkService_MemSetOnEvict(b *testing.B) {
+	for _, description := range []string{"without listener", "with listener"} {
+		b.Run(description, func(b *testing.B) {
+			cache, err := New(&Config{SizeMb: 16, Segments: 4})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer cache.Close()
+			if description == "with listener" {
+				cache.OnEvict = func(key string, value []byte, reason EvictReason) {}
+			}
+			var maxSwitch time.Duration
+			cache.OnSegmentSwitch = func(index, keys uint32, timeTaken time.Duration) {
+				if timeTaken > maxSwitch {
+					maxSwitch = timeTaken
+				}
+			}
+			keys := make([]string, 100000)
+			for i := range keys {
+				keys[i] = strconv.Itoa(i)
+			}
+			payload := []byte(strings.Repeat("?", 256))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Set(keys[i%len(keys)], payload)
+			}
+			b.ReportMetric(float64(maxSwitch.Microseconds()), "max-switch-µs")
+		})
+	}
+}
@@ -1,6 +1,6 @@
 package scache
 
-func newDefaultHasher() fnv64a {
+func newDefaultHasher() Hasher {
 	return fnv64a{}
 }
 
@@ -13,8 +13,8 @@ const (
 	prime64 = 1099511628211
 )
 
-// Sum64 gets the string and returns its uint64 hash value.
-func (f fnv64a) Sum64(key string) uint64 {
+// Sum64 gets the key bytes and returns its uint64 hash value.
+func (f fnv64a) Sum64(key []byte) uint64 {
 	var hash uint64 = offset64
 	for i := 0; i < len(key); i++ {
 		hash ^= uint64(key[i])
@@ -0,0 +1,78 @@
+package scache
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/rand/v2"
+)
+
+const (
+	//HashFNV unseeded FNV-1a key hash, deterministic across processes
+	HashFNV = "fnv"
+	//HashXX seeded xxhash64 key hash, deterministic for the same seed, default for memory mapped file cache
+	HashXX = "xxhash"
+	//HashMaphash hash/maphash key hash with per process random seed, default for memory cache
+	HashMaphash = "maphash"
+)
+
+// hash identifiers stored in memory mapped file header
+const (
+	customHashID = iota
+	fnvHashID
+	xxHashID
+)
+
+// Hasher represents key hasher
+type Hasher interface {
+	Sum64(key []byte) uint64
+}
+
+type maphashHasher struct {
+	seed maphash.Seed
+}
+
+// Sum64 returns key hash
+func (h *maphashHasher) Sum64(key []byte) uint64 {
+	return maphash.Bytes(h.seed, key)
+}
+
+func randomSeed() uint64 {
+	return rand.Uint64()
+}
+
+// hashID returns configured hash identifier persisted with memory mapped file
+func (c *Config) hashID() uint32 {
+	if c.Hasher != nil {
+		return customHashID
+	}
+	switch c.Hash {
+	case HashFNV:
+		return fnvHashID
+	case HashXX:
+		return xxHashID
+	}
+	return customHashID
+}
+
+// newHasher creates configured key hasher
+func (c *Config) newHasher() (Hasher, error) {
+	if c.Hasher != nil {
+		return c.Hasher, nil
+	}
+	switch c.Hash {
+	case HashFNV:
+		return fnv64a{}, nil
+	case HashXX:
+		seed := c.HashSeed
+		if seed == 0 {
+			seed = randomSeed()
+		}
+		return xxHasher{seed: seed}, nil
+	case HashMaphash:
+		if c.Location != "" {
+			return nil, fmt.Errorf("%v hash is not supported with memory mapped file, since its seed can not be persisted", c.Hash)
+		}
+		return &maphashHasher{seed: maphash.MakeSeed()}, nil
+	}
+	return nil, fmt.Errorf("unsupported hash: %v", c.Hash)
+}
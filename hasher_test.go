@@ -0,0 +1,122 @@
+package scache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestXxHasher_Sum64(t *testing.T) {
+	var useCases = []struct {
+		description string
+		seed        uint64
+		input       string
+		expect      uint64
+	}{
+		{description: "empty", input: "", expect: 0xef46db3751d8e999},
+		{description: "single byte", input: "a", expect: 0xd24ec4f1a98c6e5b},
+		{description: "short", input: "abc", expect: 0x44bc2cf5ad770999},
+		{description: "long", input: "Nobody inspects the spammish repetition", expect: 0xfbcea83c8a378bf1},
+	}
+	for _, useCase := range useCases {
+		assert.EqualValues(t, useCase.expect, xxHasher{seed: useCase.seed}.Sum64([]byte(useCase.input)), useCase.description)
+	}
+	long := strings.Repeat("scache", 20)
+	assert.NotEqual(t, xxHasher{seed: 1}.Sum64([]byte(long)), xxHasher{seed: 2}.Sum64([]byte(long)))
+	assert.EqualValues(t, xxHasher{seed: 1}.Sum64([]byte(long)), xxHasher{seed: 1}.Sum64([]byte(long)))
+}
+
+func TestConfig_newHasher(t *testing.T) {
+	var useCases = []struct {
+		description string
+		config      *Config
+		hasError    bool
+		seeded      bool
+	}{
+		{description: "memory default", config: &Config{}, seeded: true},
+		{description: "fnv", config: &Config{Hash: HashFNV}},
+		{description: "xxhash random seed", config: &Config{Hash: HashXX}, seeded: true},
+		{description: "maphash with memory mapped file", config: &Config{Hash: HashMaphash, Location: "/tmp/scache.hash"}, hasError: true},
+		{description: "unsupported", config: &Config{Hash: "md5"}, hasError: true},
+	}
+	for _, useCase := range useCases {
+		useCase.config.Init()
+		first, err := useCase.config.newHasher()
+		if useCase.hasError {
+			assert.NotNil(t, err, useCase.description)
+			continue
+		}
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		second, _ := useCase.config.newHasher()
+		assert.EqualValues(t, !useCase.seeded, first.Sum64([]byte("key")) == second.Sum64([]byte("key")), useCase.description)
+	}
+	custom := fnv64a{}
+	hasher, err := (&Config{Hasher: custom}).newHasher()
+	assert.Nil(t, err)
+	assert.EqualValues(t, custom, hasher)
+}
+
+func TestCache_HashSeedPersistence(t *testing.T) {
+	location := t.TempDir() + "/scache.mmap"
+	cache, err := New(&Config{Location: location})
+	if !assert.Nil(t, err) {
+		return
+	}
+	seed := cache.config.HashSeed
+	assert.True(t, seed != 0)
+	assert.Nil(t, cache.Set("key", []byte("value")))
+	assert.Nil(t, cache.Close())
+
+	cache, err = New(&Config{Location: location})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.EqualValues(t, seed, cache.config.HashSeed)
+	value, err := cache.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "value", string(value))
+	assert.Nil(t, cache.Close())
+
+	_, err = New(&Config{Location: location, HashSeed: seed + 1})
+	assert.NotNil(t, err)
+	_, err = New(&Config{Location: location, Hash: HashFNV})
+	assert.NotNil(t, err)
+}
+
+func TestNew_SharedConfig(t *testing.T) {
+	config := &Config{SizeMb: 1}
+	first, err := New(config)
+	if !assert.Nil(t, err) {
+		return
+	}
+	second, err := New(config)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, cache := range []*Cache{first, second} {
+		assert.Nil(t, cache.MSet([]string{"a", "b", "c"}, [][]byte{[]byte("1"), []byte("2"), []byte("3")}))
+		assert.Nil(t, cache.Set("d", []byte("4")))
+		value, err := cache.Get("a")
+		assert.Nil(t, err)
+		assert.EqualValues(t, "1", string(value))
+		values, err := cache.MGet([]string{"b", "d"})
+		assert.Nil(t, err)
+		assert.EqualValues(t, [][]byte{[]byte("2"), []byte("4")}, values)
+	}
+
+	//resolved hash seed is not written back to the config template
+	template := &Config{SizeMb: 1}
+	for _, tenant := range []string{"a", "b"} {
+		config := *template
+		config.Location = t.TempDir() + "/" + tenant + ".mmap"
+		cache, err := New(&config)
+		if !assert.Nil(t, err, tenant) {
+			continue
+		}
+		assert.EqualValues(t, 0, config.HashSeed, tenant)
+		assert.Nil(t, cache.Close(), tenant)
+		template = &config
+	}
+}
@@ -0,0 +1,145 @@
+package scache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+const (
+	fileMagic   = 0x464D4353 //SCMF
+	fileVersion = 5
+	//fileHeaderSize reserved memory mapped file header region, multiple of any supported page size
+	fileHeaderSize = 64 * 1024
+)
+
+// fileHeader represents memory mapped file header describing data layout
+type fileHeader struct {
+	Magic       uint32
+	Version     uint32
+	Alignment   uint32
+	Segments    uint32
+	SegmentSize uint64
+	Created     int64
+	Clean       uint32
+	Checksum    uint32
+	Hash        uint32
+	HashSeed    uint64
+	Compression uint32
+}
+
+func newFileHeader(config *Config) *fileHeader {
+	return &fileHeader{
+		Magic:       fileMagic,
+		Version:     fileVersion,
+		Alignment:   uint32(config.Alignment),
+		Segments:    uint32(config.Segments),
+		SegmentSize: uint64(config.SegmentDataSize()),
+		Created:     time.Now().UnixNano(),
+		Checksum:    boolToUint32(config.Checksum),
+		Hash:        config.hashID(),
+		HashSeed:    config.HashSeed,
+		Compression: config.compressionID(),
+	}
+}
+
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// validate checks if header layout matches expected one
+func (h *fileHeader) validate(expected *fileHeader, location string) error {
+	var mismatch = func(field string, expected, actual uint64) error {
+		return &LayoutMismatch{Location: location, Field: field, Expected: expected, Actual: actual}
+	}
+	switch {
+	case h.Magic != expected.Magic:
+		return mismatch("Magic", uint64(expected.Magic), uint64(h.Magic))
+	case h.Version != expected.Version:
+		return mismatch("Version", uint64(expected.Version), uint64(h.Version))
+	case h.Alignment != expected.Alignment:
+		return mismatch("Alignment", uint64(expected.Alignment), uint64(h.Alignment))
+	case h.Segments != expected.Segments:
+		return mismatch("Segments", uint64(expected.Segments), uint64(h.Segments))
+	case h.SegmentSize != expected.SegmentSize:
+		return mismatch("SegmentSize", expected.SegmentSize, h.SegmentSize)
+	case h.Checksum != expected.Checksum:
+		return mismatch("Checksum", uint64(expected.Checksum), uint64(h.Checksum))
+	case h.Hash != expected.Hash:
+		return mismatch("Hash", uint64(expected.Hash), uint64(h.Hash))
+	case expected.HashSeed != 0 && h.HashSeed != expected.HashSeed:
+		return mismatch("HashSeed", expected.HashSeed, h.HashSeed)
+	case h.Compression != expected.Compression:
+		return mismatch("Compression", uint64(expected.Compression), uint64(h.Compression))
+	}
+	return nil
+}
+
+func (m *mmap) readHeader() (*fileHeader, error) {
+	header := &fileHeader{}
+	reader := io.NewSectionReader(m.file, 0, fileHeaderSize)
+	if err := binary.Read(reader, binary.LittleEndian, header); err != nil {
+		return nil, errors.Wrapf(err, "failed to read header: %v", m.location)
+	}
+	return header, nil
+}
+
+func (m *mmap) writeHeader(header *fileHeader) error {
+	buffer := new(bytes.Buffer)
+	if err := binary.Write(buffer, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if _, err := m.file.WriteAt(buffer.Bytes(), 0); err != nil {
+		return errors.Wrapf(err, "failed to write header: %v", m.location)
+	}
+	return m.file.Sync()
+}
+
+// openFile opens memory mapped file and validates its header against the config,
+// a file without header is initialised, it returns true if file was closed cleanly
+func (s *Cache) openFile() (bool, error) {
+	s.mmap = newMmap(s.config.Location, fileHeaderSize+s.config.SizeMb*mb)
+	if err := s.mmap.open(); err != nil {
+		return false, err
+	}
+	expected := newFileHeader(s.config)
+	header, err := s.mmap.readHeader()
+	if err != nil {
+		return false, err
+	}
+	if header.Magic != 0 {
+		if err = header.validate(expected, s.config.Location); err == nil {
+			s.header = header
+			return header.Clean == 1, s.initHasher(header.HashSeed)
+		}
+		if !s.config.ReinitializeOnMismatch {
+			return false, err
+		}
+	}
+	if expected.HashSeed == 0 {
+		expected.HashSeed = randomSeed()
+	}
+	s.header = expected
+	if err = s.mmap.writeHeader(expected); err != nil {
+		return false, err
+	}
+	return false, s.initHasher(expected.HashSeed)
+}
+
+// initHasher initialises key hasher with the hash seed persisted in the file header
+func (s *Cache) initHasher(seed uint64) (err error) {
+	s.config.HashSeed = seed
+	s.config.hasher, err = s.config.newHasher()
+	return err
+}
+
+// markClean updates file header clean shutdown flag
+func (s *Cache) markClean(clean bool) error {
+	s.header.Clean = boolToUint32(clean)
+	return s.mmap.writeHeader(s.header)
+}
@@ -0,0 +1,149 @@
+package scache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+const (
+	indexMagic   = 0x58494353 //SCIX
+	indexVersion = 1
+	indexSuffix  = ".idx"
+)
+
+// indexLocation returns location of persisted key index for memory mapped file cache
+func (c *Config) indexLocation() string {
+	return c.Location + indexSuffix
+}
+
+// persistIndex writes active segment index, segments tail, keys and key hash to address mapping to the index file
+func (s *Cache) persistIndex() error {
+	location := s.config.indexLocation()
+	file, err := os.OpenFile(location, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePermission)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create index file: %v", location)
+	}
+	writer := bufio.NewWriter(file)
+	err = s.writeIndex(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(location)
+		return errors.Wrapf(err, "failed to persist index: %v", location)
+	}
+	return nil
+}
+
+func (s *Cache) writeIndex(writer io.Writer) error {
+	header := []uint64{indexMagic, indexVersion, uint64(s.config.SizeMb), s.config.Shards, uint64(len(s.segments)), uint64(s.index)}
+	if err := binary.Write(writer, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	var buffer [12]byte
+	for i := range s.segments {
+		segment := &s.segments[i]
+		if err := binary.Write(writer, binary.LittleEndian, []uint64{segment.tail, uint64(segment.keys)}); err != nil {
+			return err
+		}
+		shardedMap := segment.getShardedMap()
+		for j, aMap := range shardedMap.maps {
+			shardedMap.lock[j].RLock()
+			err := binary.Write(writer, binary.LittleEndian, uint64(aMap.Count()))
+			aMap.Iter(func(hashedKey uint64, address uint32) bool {
+				if err != nil {
+					return true
+				}
+				binary.LittleEndian.PutUint64(buffer[:8], hashedKey)
+				binary.LittleEndian.PutUint32(buffer[8:], address)
+				_, err = writer.Write(buffer[:])
+				return false
+			})
+			shardedMap.lock[j].RUnlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadIndex restores index persisted by the previous clean close, the index file is removed once loaded,
+// thus index is never restored after unclean shutdown
+func (s *Cache) loadIndex(restore bool) error {
+	location := s.config.indexLocation()
+	file, err := os.Open(location)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to open index file: %v", location)
+	}
+	if restore {
+		if err = s.readIndex(bufio.NewReader(file)); err != nil {
+			s.resetSegments() //invalid index is discarded, cache starts empty
+		}
+	}
+	_ = file.Close()
+	if err = os.Remove(location); err != nil {
+		return errors.Wrapf(err, "failed to remove index file: %v", location)
+	}
+	return nil
+}
+
+func (s *Cache) readIndex(reader io.Reader) error {
+	header := make([]uint64, 6)
+	if err := binary.Read(reader, binary.LittleEndian, header); err != nil {
+		return errors.Wrap(err, "failed to read index header")
+	}
+	if header[0] != indexMagic || header[1] != indexVersion {
+		return errors.Errorf("unsupported index format: %x, version: %v", header[0], header[1])
+	}
+	if header[2] != uint64(s.config.SizeMb) || header[3] != s.config.Shards || header[4] != uint64(len(s.segments)) || header[5] >= header[4] {
+		return nil //cache layout has changed, index is discarded
+	}
+	var buffer [12]byte
+	for i := range s.segments {
+		segment := &s.segments[i]
+		state := make([]uint64, 2)
+		if err := binary.Read(reader, binary.LittleEndian, state); err != nil {
+			return errors.Wrap(err, "failed to read segment state")
+		}
+		if state[0] > segment.dataSize {
+			return errors.Errorf("invalid segment %v tail: %v", i, state[0])
+		}
+		segment.tail, segment.keys = state[0], uint32(state[1])
+		shardedMap := segment.getShardedMap()
+		for _, aMap := range shardedMap.maps {
+			var count uint64
+			if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+				return errors.Wrap(err, "failed to read shard size")
+			}
+			for j := uint64(0); j < count; j++ {
+				if _, err := io.ReadFull(reader, buffer[:]); err != nil {
+					return errors.Wrap(err, "failed to read shard entry")
+				}
+				address := binary.LittleEndian.Uint32(buffer[8:])
+				if uint64(address)<<s.config.alignmentShift >= segment.tail {
+					return errors.Errorf("invalid segment %v address: %v", i, address)
+				}
+				aMap.Put(binary.LittleEndian.Uint64(buffer[:8]), address)
+			}
+		}
+	}
+	s.index = uint32(header[5])
+	return nil
+}
+
+func (s *Cache) resetSegments() {
+	for i := range s.segments {
+		s.segments[i].reset()
+	}
+	s.index = 0
+}
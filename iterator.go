@@ -0,0 +1,119 @@
+package scache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rangeBuffer holds shard entries copied out of the segment data, so that range callback runs without segment lease
+type rangeBuffer struct {
+	data    []byte
+	entries []rangeEntry
+}
+
+type rangeEntry struct {
+	keyEnd   int
+	valueEnd int
+	flags    byte
+	live     bool
+}
+
+func (b *rangeBuffer) reset() {
+	b.data = b.data[:0]
+	b.entries = b.entries[:0]
+}
+
+func (b *rangeBuffer) append(key, value []byte, flags byte, live bool) {
+	b.data = append(b.data, key...)
+	keyEnd := len(b.data)
+	b.data = append(b.data, value...)
+	b.entries = append(b.entries, rangeEntry{keyEnd: keyEnd, valueEnd: len(b.data), flags: flags, live: live})
+}
+
+// Range calls fn for each live key with its freshest value across all segments, iteration stops when fn returns false.
+// Range can run concurrently with other operations, entries modified during iteration may or may not be reported,
+// value is only valid during fn call
+func (s *Cache) Range(fn func(key string, value []byte) bool) error {
+	return s.iterate(true, fn)
+}
+
+// Keys calls fn for each live key across all segments, iteration stops when fn returns false
+func (s *Cache) Keys(fn func(key string) bool) error {
+	return s.iterate(false, func(key string, _ []byte) bool {
+		return fn(key)
+	})
+}
+
+func (s *Cache) iterate(withValues bool, fn func(key string, value []byte) bool) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	idx := atomic.LoadUint32(&s.index)
+	buffer := &rangeBuffer{}
+	seen := map[string]struct{}{}
+	for generation := uint32(0); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		if !s.iterateSegment(segment, seen, withValues, buffer, fn) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// iterateSegment calls fn for segment entries shard by shard, skipping keys seen in newer segments, including
+// deleted and expired ones, keys promoted to the newer segments during iteration are still reported once,
+// it returns false if iteration was stopped by fn
+func (s *Cache) iterateSegment(segment *segment, seen map[string]struct{}, withValues bool, buffer *rangeBuffer, fn func(key string, value []byte) bool) bool {
+	shardedMap := segment.getShardedMap()
+	now := uint64(time.Now().UnixNano())
+	for i := range shardedMap.maps {
+		buffer.reset()
+		if !segment.acquire() { //segment is being recycled, thus it has no live entries
+			return true
+		}
+		shardedMap.lock[i].RLock()
+		shardedMap.maps[i].Iter(func(_ uint64, address uint32) bool {
+			if address == 0 {
+				return false
+			}
+			headerAddress := uint64(address) << segment.config.alignmentShift
+			key, value, has := segment.storedEntry(headerAddress)
+			if !has { //corrupted entry
+				return false
+			}
+			flags := segment.flags(headerAddress)
+			expiry := segment.expiry(headerAddress)
+			live := flags&negativeEntry == 0 && (expiry == 0 || expiry > now)
+			if !withValues || !live {
+				value, flags = nil, 0
+			}
+			buffer.append(key, value, flags, live)
+			return false
+		})
+		shardedMap.lock[i].RUnlock()
+		segment.release()
+
+		offset := 0
+		for _, entry := range buffer.entries {
+			key := buffer.data[offset:entry.keyEnd]
+			value := buffer.data[entry.keyEnd:entry.valueEnd]
+			offset = entry.valueEnd
+			if _, ok := seen[string(key)]; ok {
+				continue
+			}
+			seen[string(key)] = struct{}{}
+			if !entry.live {
+				continue
+			}
+			var has bool
+			if value, has = s.entryValue(key, value, entry.flags); !has { //evicted large value or corrupted entry is skipped
+				continue
+			}
+			if !fn(string(key), value) {
+				return false
+			}
+		}
+	}
+	return true
+}
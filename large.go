@@ -0,0 +1,433 @@
+package scache
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	largeChunkSize = 64 * 1024
+	//largePointerSize segment entry value size pointing to the large value: large entry id and value size
+	largePointerSize = 16
+)
+
+// largeObject represents large value stored in chunks of the large object area
+type largeObject struct {
+	key     string
+	id      uint64
+	size    int
+	chunks  []uint32
+	readers int32
+	removed bool
+}
+
+// largeArea represents large object area storing values not fitting a segment in fixed size chunks,
+// the oldest entries are evicted when the area runs out of free chunks, area is memory resident,
+// thus large values of memory mapped file cache are not restored after restart
+type largeArea struct {
+	mutex   sync.Mutex
+	data    []byte
+	free    []uint32
+	entries map[string]*largeObject
+	queue   []*largeObject
+	nextID  uint64
+	count   int32
+}
+
+// allocate returns free chunk index evicting the oldest entries when needed, it returns false if all chunks are in use,
+// evicted values are copied before their chunks are reused and supplied to onEvict, if specified, without the area lock
+func (a *largeArea) allocate(onEvict func(key string, id uint64, value []byte)) (uint32, bool) {
+	var evicted []*largeObject
+	var values [][]byte
+	a.mutex.Lock()
+	for len(a.free) == 0 && len(a.queue) > 0 {
+		entry := a.queue[0]
+		a.queue[0] = nil
+		a.queue = a.queue[1:]
+		if entry.removed {
+			continue
+		}
+		if onEvict != nil {
+			evicted = append(evicted, entry)
+			values = append(values, a.appendTo(make([]byte, 0, entry.size), entry))
+		}
+		a.remove(entry)
+	}
+	chunk, ok := uint32(0), len(a.free) > 0
+	if ok {
+		chunk = a.free[len(a.free)-1]
+		a.free = a.free[:len(a.free)-1]
+	}
+	a.mutex.Unlock()
+	for i, entry := range evicted {
+		onEvict(entry.key, entry.id, values[i])
+	}
+	return chunk, ok
+}
+
+// chunk returns chunk data
+func (a *largeArea) chunk(index uint32) []byte {
+	offset := int(index) * largeChunkSize
+	return a.data[offset : offset+largeChunkSize]
+}
+
+// write stores prefix followed by reader data as the key large value, it returns large entry id and value size,
+// values evicted to make room for the value are supplied to onEvict, if specified
+func (a *largeArea) write(key string, prefix []byte, reader io.Reader, onEvict func(key string, id uint64, value []byte)) (uint64, int, error) {
+	entry, err := a.store(key, prefix, reader, onEvict)
+	if err != nil {
+		return 0, 0, err
+	}
+	a.publish(entry)
+	return entry.id, entry.size, nil
+}
+
+// store writes prefix followed by reader data to the area chunks, it returns large entry which is not visible
+// to readers until published, or discarded, values evicted to make room for the value are supplied to onEvict, if specified
+func (a *largeArea) store(key string, prefix []byte, reader io.Reader, onEvict func(key string, id uint64, value []byte)) (*largeObject, error) {
+	if reader == nil && len(prefix) > len(a.data) {
+		return nil, ErrEntryTooLarge
+	}
+	entry := &largeObject{key: key}
+	var peek [1]byte
+	for {
+		if len(prefix) == 0 { //peek reader before allocating chunk, so that no chunk is evicted at the value end
+			if reader == nil {
+				break
+			}
+			n, err := reader.Read(peek[:])
+			if err == io.EOF && n == 0 {
+				break
+			}
+			if err != nil && err != io.EOF {
+				a.release(entry.chunks)
+				return nil, err
+			}
+			prefix = peek[:n]
+		}
+		if len(entry.chunks)*largeChunkSize == len(a.data) { //streamed value exceeds the area, no other entry is evicted for it
+			a.release(entry.chunks)
+			return nil, ErrEntryTooLarge
+		}
+		index, ok := a.allocate(onEvict)
+		if !ok {
+			a.release(entry.chunks)
+			return nil, ErrEntryTooLarge
+		}
+		chunk := a.chunk(index)
+		size := copy(chunk, prefix)
+		prefix = prefix[size:]
+		if reader != nil && len(prefix) == 0 && size < len(chunk) {
+			n, err := io.ReadFull(reader, chunk[size:])
+			size += n
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				a.release(append(entry.chunks, index))
+				return nil, err
+			}
+		}
+		entry.chunks = append(entry.chunks, index)
+		entry.size += size
+	}
+	a.mutex.Lock()
+	a.nextID++
+	entry.id = a.nextID
+	a.mutex.Unlock()
+	return entry, nil
+}
+
+// publish makes stored entry the key large value replacing the previous one, it returns false if published entry
+// has been already evicted
+func (a *largeArea) publish(entry *largeObject) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if entry.removed {
+		return false
+	}
+	key := entry.key
+	if previous, ok := a.entries[key]; ok {
+		if previous == entry {
+			return true
+		}
+		a.remove(previous)
+	}
+	a.entries[key] = entry
+	a.queue = append(a.queue, entry)
+	if len(a.queue) > 2*len(a.entries)+64 { //compact removed entries
+		queue := a.queue[:0]
+		for _, candidate := range a.queue {
+			if !candidate.removed {
+				queue = append(queue, candidate)
+			}
+		}
+		a.queue = queue
+	}
+	atomic.StoreInt32(&a.count, int32(len(a.entries)))
+	return true
+}
+
+// discard removes stored entry which segment pointer was not set
+func (a *largeArea) discard(entry *largeObject) {
+	a.mutex.Lock()
+	if !entry.removed {
+		a.remove(entry)
+	}
+	a.mutex.Unlock()
+}
+
+// acquire returns large entry referenced by the pointer, entry chunks are not reused until the entry is released
+func (a *largeArea) acquire(key []byte, pointer []byte) (*largeObject, bool) {
+	if len(pointer) != largePointerSize {
+		return nil, false
+	}
+	id := binary.LittleEndian.Uint64(pointer[:8])
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	entry, ok := a.entries[string(key)]
+	if !ok || entry.id != id {
+		return nil, false
+	}
+	entry.readers++
+	return entry, true
+}
+
+// current checks if the supplied id identifies the key large entry
+func (a *largeArea) current(key []byte, id uint64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	entry, ok := a.entries[string(key)]
+	return ok && entry.id == id
+}
+
+// releaseEntry unregisters large entry reader
+func (a *largeArea) releaseEntry(entry *largeObject) {
+	a.mutex.Lock()
+	if entry.readers--; entry.readers == 0 && entry.removed {
+		a.free = append(a.free, entry.chunks...)
+	}
+	a.mutex.Unlock()
+}
+
+// delete removes the key large value, non zero id removes only the matching entry
+func (a *largeArea) delete(key []byte, id uint64) {
+	if atomic.LoadInt32(&a.count) == 0 {
+		return
+	}
+	a.mutex.Lock()
+	if entry, ok := a.entries[string(key)]; ok && (id == 0 || entry.id == id) {
+		a.remove(entry)
+	}
+	a.mutex.Unlock()
+}
+
+// remove removes entry, its chunks are freed once the last reader releases it, caller holds the mutex
+func (a *largeArea) remove(entry *largeObject) {
+	if current, ok := a.entries[entry.key]; ok && current == entry {
+		delete(a.entries, entry.key)
+		atomic.StoreInt32(&a.count, int32(len(a.entries)))
+	}
+	entry.removed = true
+	if entry.readers == 0 {
+		a.free = append(a.free, entry.chunks...)
+	}
+}
+
+func (a *largeArea) release(chunks []uint32) {
+	a.mutex.Lock()
+	a.free = append(a.free, chunks...)
+	a.mutex.Unlock()
+}
+
+// appendTo appends large entry value to dst
+func (a *largeArea) appendTo(dst []byte, entry *largeObject) []byte {
+	remaining := entry.size
+	for _, index := range entry.chunks {
+		chunk := a.chunk(index)
+		if remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		dst = append(dst, chunk...)
+		remaining -= len(chunk)
+	}
+	return dst
+}
+
+// writeTo writes large entry value to the writer
+func (a *largeArea) writeTo(writer io.Writer, entry *largeObject) (int64, error) {
+	written := int64(0)
+	remaining := entry.size
+	for _, index := range entry.chunks {
+		chunk := a.chunk(index)
+		if remaining < len(chunk) {
+			chunk = chunk[:remaining]
+		}
+		n, err := writer.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		remaining -= len(chunk)
+	}
+	return written, nil
+}
+
+// value returns copy of the large value referenced by the pointer
+func (a *largeArea) value(key []byte, pointer []byte) ([]byte, bool) {
+	entry, ok := a.acquire(key, pointer)
+	if !ok {
+		return nil, false
+	}
+	value := a.appendTo(make([]byte, 0, entry.size), entry)
+	a.releaseEntry(entry)
+	return value, true
+}
+
+func newLargeArea(sizeMb int) *largeArea {
+	chunks := sizeMb * mb / largeChunkSize
+	area := &largeArea{
+		data:    make([]byte, chunks*largeChunkSize),
+		free:    make([]uint32, chunks),
+		entries: make(map[string]*largeObject),
+	}
+	for i := range area.free {
+		area.free[i] = uint32(chunks - 1 - i)
+	}
+	return area
+}
+
+// acquireLarge returns large entry referenced by the segment entry pointer, it returns false if large value was evicted
+func (s *Cache) acquireLarge(key []byte, pointer []byte) (*largeObject, bool) {
+	if s.large == nil {
+		return nil, false
+	}
+	return s.large.acquire(key, pointer)
+}
+
+// largeValue returns copy of the large value referenced by the segment entry pointer
+func (s *Cache) largeValue(key []byte, pointer []byte) ([]byte, bool) {
+	if s.large == nil {
+		return nil, false
+	}
+	return s.large.value(key, pointer)
+}
+
+// isLarge checks if value is stored in the large object area
+func (s *Cache) isLarge(key []byte, valueSize int) bool {
+	if s.config.LargeValueSize > 0 && valueSize > s.config.LargeValueSize {
+		return true
+	}
+	return !s.segments[0].fits(len(key), valueSize, 0)
+}
+
+// setLarge stores value in the large object area and sets segment entry pointing to it
+func (s *Cache) setLarge(key []byte, prefix []byte, reader io.Reader, ttl time.Duration) error {
+	var evicted []byte
+	var onEvict func(key string, id uint64, value []byte)
+	hasEvicted := false
+	if s.OnEvict != nil { //replaced value is read before the large write removes it and notified once the pointer is set
+		evicted, hasEvicted = s.evicted(key)
+		onEvict = s.notifyLargeEvict
+	}
+	id, size, err := s.large.write(string(key), prefix, reader, onEvict)
+	if err != nil {
+		atomic.AddUint64(&s.counters.failedSets, 1)
+		return errors.Wrapf(err, "failed to set key: %s", key)
+	}
+	set, err := s.setLargePointer(key, id, size, ttl)
+	if set && hasEvicted {
+		s.OnEvict(string(key), evicted, EvictOverwrite)
+	}
+	return err
+}
+
+// setLargePointer sets segment entry pointing to the large entry, the pointer is set under the key shard lock only if
+// the large entry was not replaced by concurrent write of the same key, so that segment never points to removed entry,
+// it returns true if the pointer was set
+func (s *Cache) setLargePointer(key []byte, id uint64, size int, ttl time.Duration) (bool, error) {
+	pointer := largePointer(id, size)
+	expiry := expiryTime(ttl)
+	version := s.nextVersion(1)
+	for attempt := 0; attempt < 2; attempt++ {
+		idx := atomic.LoadUint32(&s.index)
+		accepted, ok := s.segments[idx].setIf(key, pointer, expiry, largeEntry, func(headerAddress uint64) (uint64, bool) {
+			return version, s.large.current(key, id)
+		})
+		if !accepted { //newer value of the key was written in the meantime
+			return false, nil
+		}
+		if ok {
+			atomic.AddUint64(&s.counters.sets, 1)
+			atomic.AddUint64(&s.counters.bytesWritten, uint64(size))
+			return true, nil
+		}
+		if attempt == 0 {
+			s.switchSegment(idx)
+		}
+	}
+	s.large.delete(key, id)
+	return false, s.setError(&s.segments[atomic.LoadUint32(&s.index)], key)
+}
+
+// largePointer returns segment entry value pointing to the large entry
+func largePointer(id uint64, size int) []byte {
+	pointer := make([]byte, largePointerSize)
+	binary.LittleEndian.PutUint64(pointer[:8], id)
+	binary.LittleEndian.PutUint64(pointer[8:], uint64(size))
+	return pointer
+}
+
+// SetReader sets key with value read from the reader, value is buffered until it is known to be stored in the large
+// object area, then the rest of the value is streamed there without buffering, entry expires after Config.DefaultTTL if specified
+func (s *Cache) SetReader(key string, reader io.Reader) error {
+	if !s.enter() {
+		return ErrClosed
+	}
+	defer s.exit()
+	if s.large == nil {
+		value, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return s.set(keyBytes(key), value, s.config.DefaultTTL, 0)
+	}
+	var prefix []byte
+	for !s.isLarge(keyBytes(key), len(prefix)) {
+		offset := len(prefix)
+		prefix = append(prefix, make([]byte, largeChunkSize)...)
+		n, err := io.ReadFull(reader, prefix[offset:])
+		prefix = prefix[:offset+n]
+		switch err {
+		case io.EOF, io.ErrUnexpectedEOF:
+			return s.set(keyBytes(key), prefix, s.config.DefaultTTL, 0)
+		case nil:
+		default:
+			return err
+		}
+	}
+	return s.setLarge(keyBytes(key), prefix, reader, s.config.DefaultTTL)
+}
+
+// GetWriter writes value for the supplied key to the writer, large value is streamed chunk by chunk, it returns number of bytes written
+func (s *Cache) GetWriter(key string, writer io.Writer) (int64, error) {
+	var large *largeObject
+	var written int64
+	var err error
+	viewErr := s.view(keyBytes(key), func(value []byte, _ uint64) {
+		var n int
+		n, err = writer.Write(value)
+		written = int64(n)
+	}, func(entry *largeObject) {
+		large = entry
+	})
+	if viewErr != nil {
+		return 0, viewErr
+	}
+	if large != nil {
+		written, err = s.large.writeTo(writer, large)
+		s.large.releaseEntry(large)
+	}
+	return written, err
+}
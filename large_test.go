@@ -0,0 +1,238 @@
+package scache
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCache_LargeValue(t *testing.T) {
+	var useCases = []struct {
+		description string
+		config      *Config
+		valueSize   int
+		write       string
+		large       bool
+		expectErr   bool
+	}{
+		{
+			description: "value exceeding segment size",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   3 * mb / 2,
+			large:       true,
+		},
+		{
+			description: "value above large value size",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 1, LargeValueSize: 1024},
+			valueSize:   2048,
+			large:       true,
+		},
+		{
+			description: "streamed value",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   2*mb + 123,
+			write:       "stream",
+			large:       true,
+		},
+		{
+			description: "streamed small value",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   100,
+			write:       "stream",
+		},
+		{
+			description: "streamed value of chunk size",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   largeChunkSize,
+			write:       "stream",
+		},
+		{
+			description: "streamed value fitting segment",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   3*largeChunkSize + 123,
+			write:       "stream",
+		},
+		{
+			description: "compare and set value exceeding segment size",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   3 * mb / 2,
+			write:       "compareAndSet",
+			large:       true,
+		},
+		{
+			description: "set if absent value exceeding segment size",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 4},
+			valueSize:   3 * mb / 2,
+			write:       "setIfAbsent",
+			large:       true,
+		},
+		{
+			description: "value exceeding large object area",
+			config:      &Config{SizeMb: 1, LargeSizeMb: 1},
+			valueSize:   2 * mb,
+			expectErr:   true,
+		},
+		{
+			description: "large object area disabled",
+			config:      &Config{SizeMb: 1},
+			valueSize:   mb,
+			expectErr:   true,
+		},
+	}
+
+	for _, useCase := range useCases {
+		cache, err := New(useCase.config)
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		value := bytes.Repeat([]byte("0123456789"), useCase.valueSize/10+1)[:useCase.valueSize]
+		switch useCase.write {
+		case "stream":
+			err = cache.SetReader("key", bytes.NewReader(value))
+		case "compareAndSet":
+			var version uint64
+			if version, err = cache.CompareAndSet("key", 0, []byte("small")); assert.Nil(t, err, useCase.description) {
+				_, err = cache.CompareAndSet("key", version+1, value)
+				assert.NotNil(t, err, useCase.description)
+				assert.EqualValues(t, 0, cache.large.count, useCase.description)
+				_, err = cache.CompareAndSet("key", version, value)
+			}
+		case "setIfAbsent":
+			var set bool
+			set, err = cache.SetIfAbsent("key", value)
+			assert.True(t, set, useCase.description)
+			if err == nil {
+				set, err = cache.SetIfAbsent("key", []byte("small"))
+				assert.False(t, set, useCase.description)
+			}
+		default:
+			err = cache.Set("key", value)
+		}
+		if useCase.expectErr {
+			assert.NotNil(t, err, useCase.description)
+			_, err = cache.Get("key")
+			assert.NotNil(t, err, useCase.description)
+			continue
+		}
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		assert.EqualValues(t, useCase.large, cache.large.count == 1, useCase.description)
+		actual, err := cache.Get("key")
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, value, actual, useCase.description)
+
+		writer := new(bytes.Buffer)
+		written, err := cache.GetWriter("key", writer)
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, len(value), written, useCase.description)
+		assert.EqualValues(t, value, writer.Bytes(), useCase.description)
+
+		err = cache.View("key", func(actual []byte) {
+			assert.EqualValues(t, value, actual, useCase.description)
+		})
+		assert.Nil(t, err, useCase.description)
+
+		values, err := cache.MGet([]string{"key"})
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, value, values[0], useCase.description)
+
+		found := 0
+		assert.Nil(t, cache.Range(func(key string, actual []byte) bool {
+			found++
+			assert.EqualValues(t, value, actual, useCase.description)
+			return true
+		}), useCase.description)
+		assert.EqualValues(t, 1, found, useCase.description)
+
+		assert.Nil(t, cache.Delete("key"), useCase.description)
+		_, err = cache.Get("key")
+		assert.NotNil(t, err, useCase.description)
+		assert.Nil(t, cache.Close(), useCase.description)
+	}
+}
+
+func TestCache_LargeValueEviction(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, LargeSizeMb: 2, LargeValueSize: 1024})
+	if !assert.Nil(t, err) {
+		return
+	}
+	value := bytes.Repeat([]byte("x"), mb/2)
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, cache.Set(fmt.Sprintf("key%v", i), value))
+	}
+	for i := 0; i < 6; i++ {
+		actual, err := cache.Get(fmt.Sprintf("key%v", i))
+		if i < 2 {
+			assert.NotNil(t, err, "oldest large values are evicted")
+			continue
+		}
+		assert.Nil(t, err)
+		assert.EqualValues(t, value, actual)
+	}
+
+	//replacing large value with small one releases large object area chunks
+	assert.Nil(t, cache.Set("key5", []byte("small")))
+	actual, err := cache.Get("key5")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "small", string(actual))
+	assert.Nil(t, cache.Set("key6", value))
+	for i := 2; i < 5; i++ {
+		_, err := cache.Get(fmt.Sprintf("key%v", i))
+		assert.Nil(t, err, "freed chunks are reused before eviction")
+	}
+	assert.Nil(t, cache.MSet([]string{"key7", "key8"}, [][]byte{[]byte("small"), value}))
+	values, err := cache.MGet([]string{"key7", "key8"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "small", string(values[0]))
+	assert.EqualValues(t, value, values[1])
+}
+
+func TestCache_LargeValueStreamLimit(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, LargeSizeMb: 2})
+	if !assert.Nil(t, err) {
+		return
+	}
+	reader := &countingReader{}
+	err = cache.SetReader("key", reader)
+	assert.True(t, errors.Is(err, ErrEntryTooLarge))
+	assert.EqualValues(t, 2*mb+1, reader.read, "streamed value is read up to the area size")
+	assert.EqualValues(t, 2*mb/largeChunkSize, len(cache.large.free), "chunks are released")
+	value := bytes.Repeat([]byte("x"), mb)
+	assert.Nil(t, cache.SetReader("key", bytes.NewReader(value)))
+	actual, err := cache.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, value, actual)
+}
+
+//countingReader reads endless data counting bytes read
+type countingReader struct {
+	read int
+}
+
+func (r *countingReader) Read(data []byte) (int, error) {
+	r.read += len(data)
+	return len(data), nil
+}
+
+func TestCache_LargeValueConcurrentWrite(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, LargeSizeMb: 1, LargeValueSize: 1024})
+	if !assert.Nil(t, err) {
+		return
+	}
+	//earlier written large entry replaced by concurrent write of the same key does not override its pointer
+	id, size, err := cache.large.write("key", bytes.Repeat([]byte("a"), 2048), nil, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	value := bytes.Repeat([]byte("b"), 2048)
+	assert.Nil(t, cache.Set("key", value))
+	set, err := cache.setLargePointer([]byte("key"), id, size, 0)
+	assert.Nil(t, err)
+	assert.False(t, set)
+	actual, err := cache.Get("key")
+	assert.Nil(t, err)
+	assert.EqualValues(t, value, actual)
+}
@@ -4,3 +4,37 @@ import "time"
 
 //OnSegmentSwitch function to call when segment switches primary to secondary role
 type OnSegmentSwitch func(index, keys uint32, timeTaken time.Duration)
+
+//OnEvict function to call with the key value dropped from the cache, value is only valid during the call,
+//function must not modify the cache, recycled segment entries are evicted by the writer switching segments,
+//and replaced values are copied by every write, which slows down writes and segment switches
+type OnEvict func(key string, value []byte, reason EvictReason)
+
+//EvictReason represents reason of the entry removal
+type EvictReason int
+
+const (
+	//EvictRecycle entry was not promoted before its segment was recycled or large value was evicted from the large object area
+	EvictRecycle = EvictReason(iota + 1)
+	//EvictDelete entry was deleted
+	EvictDelete
+	//EvictExpired entry expired before its segment was recycled
+	EvictExpired
+	//EvictOverwrite entry was replaced with a new value
+	EvictOverwrite
+)
+
+//String returns evict reason name
+func (r EvictReason) String() string {
+	switch r {
+	case EvictRecycle:
+		return "recycle"
+	case EvictDelete:
+		return "delete"
+	case EvictExpired:
+		return "expired"
+	case EvictOverwrite:
+		return "overwrite"
+	}
+	return "unknown"
+}
@@ -0,0 +1,97 @@
+package scache
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// Loader loads value for the cache miss, returned ttl defines value time to live, zero ttl uses Config.DefaultTTL,
+// returning NoSuchKey error with positive ttl caches key absence for the ttl duration
+type Loader func(ctx context.Context) ([]byte, time.Duration, error)
+
+// loadCall represents in-flight loader call
+type loadCall struct {
+	done      chan struct{}
+	value     []byte
+	err       error
+	recovered interface{}
+}
+
+// loadGroup de-duplicates concurrent loads of the same key
+type loadGroup struct {
+	mutex sync.Mutex
+	calls map[string]*loadCall
+}
+
+// do runs fn once for concurrent callers with the same key, all callers share fn result, fn runs in background
+// with context not cancelled with the caller one, so that each caller, including the one starting the load,
+// stops waiting only when its own context is done, loader panic is raised by the caller starting the load if it still waits
+func (g *loadGroup) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &loadCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.load(context.WithoutCancel(ctx), key, call, fn)
+	}
+	g.mutex.Unlock()
+	select {
+	case <-call.done:
+		if !ok && call.recovered != nil {
+			panic(call.recovered)
+		}
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load runs fn and publishes its result to the call waiters
+func (g *loadGroup) load(ctx context.Context, key string, call *loadCall, fn func(ctx context.Context) ([]byte, error)) {
+	defer func() {
+		if call.recovered = recover(); call.recovered != nil { //waiters must not treat panicked load as successful one
+			call.value, call.err = nil, errors.Errorf("loader panicked: %v", call.recovered)
+		}
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn(ctx)
+}
+
+// GetOrLoad returns value for the supplied key, on cache miss value is loaded with the loader and stored in the cache,
+// concurrent loads of the same key are coalesced into a single loader call, which result is shared by all callers,
+// loader runs with context that is not cancelled when the caller context is, while each caller returns its own context error.
+// Cached key absence returns NoSuchKey error without calling the loader, corrupted entry is reloaded
+func (s *Cache) GetOrLoad(ctx context.Context, key string, loader Loader) ([]byte, error) {
+	value, _, negative, err := s.get(keyBytes(key))
+	if err == nil || negative || errors.Is(err, ErrClosed) {
+		return value, err
+	}
+	return s.loads.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		value, ttl, err := loader(ctx)
+		if err != nil {
+			if isNoSuchKey(err) && ttl > 0 {
+				_ = s.set(keyBytes(key), nil, ttl, negativeEntry)
+			}
+			return nil, err
+		}
+		if ttl == 0 {
+			ttl = s.config.DefaultTTL
+		}
+		_ = s.set(keyBytes(key), value, ttl, 0) //failed set is reported in stats, loaded value is returned regardless
+		return value, nil
+	})
+}
+
+func isNoSuchKey(err error) bool {
+	var ptr *NoSuchKey
+	var val NoSuchKey
+	return errors.As(err, &ptr) || errors.As(err, &val)
+}
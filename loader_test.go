@@ -0,0 +1,189 @@
+package scache
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache_GetOrLoad(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	ctx := context.Background()
+
+	var calls int32
+	release := make(chan bool)
+	loader := func(ctx context.Context) ([]byte, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("loaded"), 0, nil
+	}
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			value, err := cache.GetOrLoad(ctx, "key1", loader)
+			assert.Nil(t, err)
+			assert.EqualValues(t, "loaded", string(value))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	waitGroup.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "concurrent loads should be coalesced")
+	value, err := cache.Get("key1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "loaded", string(value))
+
+	//cached value does not call loader
+	value, err = cache.GetOrLoad(ctx, "key1", loader)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "loaded", string(value))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	//loader error is not cached
+	failing := func(ctx context.Context) ([]byte, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, time.Minute, fmt.Errorf("backend unavailable")
+	}
+	for i := 0; i < 2; i++ {
+		_, err = cache.GetOrLoad(ctx, "key2", failing)
+		assert.NotNil(t, err)
+	}
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+
+	//loaded value ttl
+	_, err = cache.GetOrLoad(ctx, "key3", func(ctx context.Context) ([]byte, time.Duration, error) {
+		return []byte("short"), 10 * time.Millisecond, nil
+	})
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = cache.Get("key3")
+	assert.NotNil(t, err)
+}
+
+func TestCache_GetOrLoadNegative(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	ctx := context.Background()
+	var calls int32
+	missing := func(ctx context.Context) ([]byte, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, 20 * time.Millisecond, &NoSuchKey{}
+	}
+	for i := 0; i < 3; i++ {
+		_, err = cache.GetOrLoad(ctx, "missing", missing)
+		assert.True(t, isNoSuchKey(err))
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "key absence should be cached")
+	_, err = cache.Get("missing")
+	assert.NotNil(t, err)
+	assert.NotNil(t, cache.View("missing", func(value []byte) {
+		t.Fatal("negative entry should not be viewed")
+	}))
+	count := 0
+	cache.Keys(func(key string) bool {
+		count++
+		return true
+	})
+	assert.EqualValues(t, 0, count)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = cache.GetOrLoad(ctx, "missing", missing)
+	assert.True(t, isNoSuchKey(err))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls), "key absence should expire")
+
+	//value set explicitly replaces cached absence
+	assert.Nil(t, cache.Set("missing", []byte("found")))
+	value, err := cache.GetOrLoad(ctx, "missing", missing)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "found", string(value))
+}
+
+func TestCache_GetOrLoadPanic(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	ctx := context.Background()
+	started := make(chan bool)
+	release := make(chan bool)
+	loader := func(ctx context.Context) ([]byte, time.Duration, error) {
+		close(started)
+		<-release
+		panic("loader failure")
+	}
+	go func() {
+		<-started
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		<-started
+		value, err := cache.GetOrLoad(ctx, "key1", func(ctx context.Context) ([]byte, time.Duration, error) {
+			return []byte("unexpected"), 0, nil
+		})
+		assert.NotNil(t, err, "waiter should get panicked load error")
+		assert.Nil(t, value)
+	}()
+	assert.Panics(t, func() {
+		_, _ = cache.GetOrLoad(ctx, "key1", loader)
+	})
+	waitGroup.Wait()
+	_, err = cache.Get("key1")
+	assert.NotNil(t, err)
+}
+
+func TestCache_GetOrLoadCancel(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+	started := make(chan bool)
+	release := make(chan bool)
+	loader := func(ctx context.Context) ([]byte, time.Duration, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		return []byte("loaded"), 0, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, err := cache.GetOrLoad(ctx, "key1", loader)
+		leaderErr <- err
+	}()
+	<-started
+	waiterValue := make(chan []byte)
+	go func() {
+		value, err := cache.GetOrLoad(context.Background(), "key1", loader)
+		assert.Nil(t, err, "waiter should not get the first caller context error")
+		waiterValue <- value
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-leaderErr, "cancelled caller should stop waiting")
+	close(release)
+	assert.EqualValues(t, "loaded", string(<-waiterValue))
+	value, err := cache.Get("key1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "loaded", string(value))
+}
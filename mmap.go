@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"os"
 	"syscall"
+	"unsafe"
 )
 
 const filePermission = 0644
@@ -13,10 +14,37 @@ type mmap struct {
 	location string
 	file     *os.File
 	size     int
+	regions  [][]byte
 }
 
-func (m *mmap) close() error {
-	return m.file.Close()
+// close unmaps mapped regions and closes the file
+func (m *mmap) close() (err error) {
+	for _, region := range m.regions {
+		if e := syscall.Munmap(region); e != nil {
+			err = errors.Wrapf(e, "failed to unmap %v", m.location)
+		}
+	}
+	m.regions = nil
+	if m.file != nil {
+		if e := m.file.Close(); e != nil {
+			err = e
+		}
+		m.file = nil
+	}
+	return err
+}
+
+// sync flushes mapped regions dirty pages to the file
+func (m *mmap) sync() error {
+	for _, region := range m.regions {
+		if len(region) == 0 {
+			continue
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&region[0])), uintptr(len(region)), syscall.MS_SYNC); errno != 0 {
+			return errors.Wrapf(errno, "failed to sync %v", m.location)
+		}
+	}
+	return nil
 }
 
 func (m *mmap) open() error {
@@ -36,6 +64,7 @@ func (m *mmap) assign(offset int64, target *[]byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to map memory %v", m.location)
 	}
+	m.regions = append(m.regions, buffer)
 	*target = buffer
 	return nil
 }
@@ -1,8 +1,12 @@
 package scache
 
 import (
+	"bytes"
 	"encoding/binary"
+	"hash/crc32"
 	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 /*
@@ -11,10 +15,35 @@ https://dev.to/douglasmakey/how-bigcache-avoids-expensive-gc-cycles-and-speeds-u
 */
 
 const (
-	headerSize  = 5
-	controlByte = 0x9A
+	headerSize     = 28
+	controlByte    = 0x9A
+	sizeOffset     = 1
+	expiryOffset   = 5
+	keySizeOffset  = 13
+	flagsOffset    = 15
+	versionOffset  = 16
+	checksumOffset = 24
+	//sharedOffset unused checksum field counts entry value leases and marks entry which value was handed out without lease,
+	//thus can not be overwritten in place
+	sharedOffset = checksumOffset
+	//sharedEntry marks entry value handed out without lease, lower bits count outstanding entry value leases
+	sharedEntry = uint32(1) << 31
+	maxKeySize  = 0xFFFF
 )
 
+const (
+	//negativeEntry flags entry caching key absence
+	negativeEntry = 1 << iota
+	//counterEntry flags entry storing 8 bytes aligned int64 counter updated in place, counter entry checksum is not verified
+	counterEntry
+	//largeEntry flags entry pointing to the value stored in the large object area
+	largeEntry
+	//compressedEntry flags entry storing value compressed with the configured compressor
+	compressedEntry
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type segment struct {
 	*shardedMap
 	config   *Config
@@ -24,108 +53,490 @@ type segment struct {
 	tail     uint64
 	keys     uint32
 	mmap     *mmap
+	//readers counts outstanding readers holding segment data, reset waits for them before the data can be reused
+	readers   int32
+	recycling int32
+	//released is signalled by the last reader releasing segment being recycled
+	released  chan struct{}
+	corrupted uint64
+}
+
+// acquire registers segment data reader, it returns false when segment is being recycled
+func (s *segment) acquire() bool {
+	atomic.AddInt32(&s.readers, 1)
+	if atomic.LoadInt32(&s.recycling) == 1 {
+		s.release()
+		return false
+	}
+	return true
+}
+
+// release unregisters segment data reader, the last reader signals recycle waiting for readers
+func (s *segment) release() {
+	if atomic.AddInt32(&s.readers, -1) == 0 && atomic.LoadInt32(&s.recycling) == 1 {
+		select {
+		case s.released <- struct{}{}:
+		default:
+		}
+	}
 }
 
+// close unmaps memory mapped segment data
 func (s *segment) close() error {
 	if s.mmap != nil {
+		s.data = nil
 		return s.mmap.close()
 	}
 	return nil
 }
 
+// sync flushes memory mapped segment data to the file
+func (s *segment) sync() error {
+	if s.mmap != nil {
+		return s.mmap.sync()
+	}
+	return nil
+}
+
 func (s *segment) reset() {
-	for i := range s.maps {
-		s.shardedMap.lock[i].Lock()
-		clearSwissMap(s.shardedMap.maps[i], keys, values)
-		s.shardedMap.lock[i].Unlock()
+	s.detach(nil)
+	s.recycle()
+}
+
+// detach replaces keys index with the supplied spare one and returns detached keys index to be cleared by the caller,
+// keys index is cleared in place when no spare is supplied
+func (s *segment) detach(spare *shardedMap) *shardedMap {
+	if spare == nil {
+		s.getShardedMap().clear()
+		return nil
+	}
+	detached := s.getShardedMap()
+	s.setShardedMap(spare)
+	return detached
+}
+
+// recycle prepares segment data for reuse once outstanding readers finish
+func (s *segment) recycle() {
+	if s.released == nil {
+		s.released = make(chan struct{}, 1)
+	}
+	atomic.StoreInt32(&s.recycling, 1)
+	for atomic.LoadInt32(&s.readers) > 0 {
+		<-s.released
 	}
-	atomic.StoreUint64(&s.tail, 32)
+	atomic.StoreUint64(&s.tail, uint64(s.config.Alignment))
 	atomic.StoreUint32(&s.keys, 0)
+	atomic.StoreInt32(&s.recycling, 0)
+}
 
+func (s *segment) get(key []byte) ([]byte, bool) {
+	_, value, has := s.lookup(key)
+	return value, has
 }
 
-func (s *segment) get(key string) ([]byte, bool) {
+// lookup returns entry header address and value for supplied key, expired entries are treated as missing,
+// non zero header address is returned for any entry stored for the key, so it can shadow entries in older segments
+func (s *segment) lookup(key []byte) (uint64, []byte, bool) {
 	shardedMap := s.getShardedMap()
-	headerAddress := shardedMap.getAddress(key)
+	headerAddress := shardedMap.getAddress(key, s)
 	if headerAddress == 0 {
-		return nil, false
+		return 0, nil, false
+	}
+	_, value, has := s.entry(headerAddress)
+	return headerAddress, value, has
+}
+
+// lookupShared returns entry header address and value like lookup, entry is marked shared under the key shard read lock,
+// so that returned value is never overwritten in place, use it when value outlives the segment lease
+func (s *segment) lookupShared(key []byte) (uint64, []byte, bool) {
+	var headerAddress uint64
+	s.getShardedMap().read(key, s, func(address uint64) {
+		if headerAddress = address; address != 0 {
+			s.share(address)
+		}
+	})
+	if headerAddress == 0 {
+		return 0, nil, false
+	}
+	_, value, has := s.entry(headerAddress)
+	return headerAddress, value, has
+}
+
+// leaseEntry returns entry header address and value like lookup, entry value lease is taken under the key shard read lock,
+// so that value is not overwritten in place until releaseEntry is called, caller holds segment lease
+func (s *segment) leaseEntry(key []byte) (uint64, []byte, bool) {
+	var headerAddress uint64
+	s.getShardedMap().read(key, s, func(address uint64) {
+		headerAddress = s.lease(address)
+	})
+	if headerAddress == 0 {
+		return 0, nil, false
+	}
+	_, value, has := s.entry(headerAddress)
+	return headerAddress, value, has
+}
+
+// tryLeaseEntry takes entry value lease like leaseEntry, it returns false instead of waiting for key shard locked for writing
+func (s *segment) tryLeaseEntry(key []byte) (uint64, bool) {
+	var headerAddress uint64
+	locked := s.getShardedMap().tryRead(key, s, func(address uint64) {
+		headerAddress = s.lease(address)
+	})
+	return headerAddress, locked
+}
+
+// lease increments entry value leases, caller holds the entry key shard lock
+func (s *segment) lease(headerAddress uint64) uint64 {
+	if headerAddress != 0 && s.sharedSlot(headerAddress) != nil {
+		atomic.AddUint32(s.sharedSlot(headerAddress), 1)
+	}
+	return headerAddress
+}
+
+// releaseEntry releases entry value lease taken by leaseEntry
+func (s *segment) releaseEntry(headerAddress uint64) {
+	if headerAddress != 0 && s.sharedSlot(headerAddress) != nil {
+		atomic.AddUint32(s.sharedSlot(headerAddress), ^uint32(0))
+	}
+}
+
+// share marks entry shared, caller holds the entry key shard lock, entries with checksum are never overwritten in place
+func (s *segment) share(headerAddress uint64) {
+	shared := s.sharedSlot(headerAddress)
+	if shared == nil {
+		return
+	}
+	for current := atomic.LoadUint32(shared); current&sharedEntry == 0; current = atomic.LoadUint32(shared) {
+		if atomic.CompareAndSwapUint32(shared, current, current|sharedEntry) {
+			return
+		}
+	}
+}
+
+// isShared checks if entry value was handed out without lease or is leased
+func (s *segment) isShared(headerAddress uint64) bool {
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(&s.data[headerAddress+sharedOffset]))) != 0
+}
+
+// sharedSlot returns entry shared marker and leases counter, it returns nil if entry stores checksum
+func (s *segment) sharedSlot(headerAddress uint64) *uint32 {
+	if s.config.Checksum || headerAddress+headerSize > atomic.LoadUint64(&s.tail) {
+		return nil
 	}
+	return (*uint32)(unsafe.Pointer(&s.data[headerAddress+sharedOffset]))
+}
+
+// entry returns key and value stored at the supplied header address, expired or corrupted entries are treated as missing
+func (s *segment) entry(headerAddress uint64) ([]byte, []byte, bool) {
+	key, value, has := s.storedEntry(headerAddress)
+	if !has {
+		return nil, nil, false
+	}
+	if expiry := s.expiry(headerAddress); expiry != 0 && expiry <= uint64(time.Now().UnixNano()) {
+		return nil, nil, false
+	}
+	return key, value, true
+}
+
+// storedEntry returns key and value stored at the supplied header address regardless of entry expiry, corrupted entries are treated as missing
+func (s *segment) storedEntry(headerAddress uint64) ([]byte, []byte, bool) {
 	headerAddressEnd := headerAddress + headerSize
 	if headerAddressEnd > s.dataSize {
-		return nil, false
+		return nil, nil, false
 	}
-	entrySize := binary.LittleEndian.Uint32(s.data[headerAddress+1 : headerAddressEnd])
+	entrySize := binary.LittleEndian.Uint32(s.data[headerAddress+sizeOffset : headerAddress+expiryOffset])
 	if headerAddressEnd > atomic.LoadUint64(&s.tail) {
-		return nil, false
+		return nil, nil, false
 	}
-	dataAddress := headerAddress + headerSize
+	keySize := binary.LittleEndian.Uint16(s.data[headerAddress+keySizeOffset : headerAddress+flagsOffset])
+	flags := s.data[headerAddress+flagsOffset]
+	dataAddress := headerAddress + uint64(valueOffset(int(keySize), flags))
 	dataAddressEnd := dataAddress + uint64(entrySize)
 	if dataAddressEnd > s.dataSize {
-		return nil, false
+		return nil, nil, false
 	}
-	result := s.data[dataAddress:dataAddressEnd]
 	if s.data[headerAddress] != controlByte {
-		return nil, false
+		return nil, nil, false
+	}
+	if s.config.Checksum && flags&counterEntry == 0 && s.checksum(headerAddress, dataAddressEnd) != binary.LittleEndian.Uint32(s.data[headerAddress+checksumOffset:headerAddressEnd]) {
+		atomic.AddUint64(&s.corrupted, 1)
+		return nil, nil, false
 	}
-	return result, true
+	return s.data[headerAddressEnd : headerAddressEnd+uint64(keySize)], s.data[dataAddress:dataAddressEnd], true
 }
 
-func (s *segment) delete(key string) {
+// isCorrupted checks if entry stored at the supplied header address fails control byte, size or checksum verification
+func (s *segment) isCorrupted(headerAddress uint64) bool {
+	headerAddressEnd := headerAddress + headerSize
+	if headerAddressEnd > s.dataSize || headerAddressEnd > atomic.LoadUint64(&s.tail) {
+		return false //stale address
+	}
+	if s.data[headerAddress] != controlByte {
+		return true
+	}
+	entrySize := binary.LittleEndian.Uint32(s.data[headerAddress+sizeOffset : headerAddress+expiryOffset])
+	keySize := binary.LittleEndian.Uint16(s.data[headerAddress+keySizeOffset : headerAddress+flagsOffset])
+	flags := s.data[headerAddress+flagsOffset]
+	dataAddressEnd := headerAddress + uint64(valueOffset(int(keySize), flags)) + uint64(entrySize)
+	if dataAddressEnd > s.dataSize {
+		return true
+	}
+	return s.config.Checksum && flags&counterEntry == 0 && s.checksum(headerAddress, dataAddressEnd) != binary.LittleEndian.Uint32(s.data[headerAddress+checksumOffset:headerAddressEnd])
+}
+
+// fits checks if entry fits an empty segment
+func (s *segment) fits(keySize, valueSize int, flags byte) bool {
+	return keySize <= maxKeySize && uint64(s.config.Alignment+s.config.alignSize(valueOffset(keySize, flags)+valueSize)) < s.dataSize
+}
+
+// valueOffset returns entry value offset relative to the header address, counter value is 8 bytes aligned for atomic updates
+func valueOffset(keySize int, flags byte) int {
+	offset := headerSize + keySize
+	if flags&counterEntry != 0 {
+		offset = (offset + 7) &^ 7
+	}
+	return offset
+}
+
+// counter returns pointer to the counter value of the entry stored at the supplied header address
+func (s *segment) counter(headerAddress uint64) *int64 {
+	keySize := binary.LittleEndian.Uint16(s.data[headerAddress+keySizeOffset : headerAddress+flagsOffset])
+	return (*int64)(unsafe.Pointer(&s.data[headerAddress+uint64(valueOffset(int(keySize), counterEntry))]))
+}
+
+// matchKey checks if entry at supplied header address stores the key
+func (s *segment) matchKey(headerAddress uint64, key []byte) bool {
+	keyAddress := headerAddress + headerSize
+	if keyAddress > s.dataSize {
+		return false
+	}
+	keySize := binary.LittleEndian.Uint16(s.data[headerAddress+keySizeOffset : headerAddress+flagsOffset])
+	if int(keySize) != len(key) || keyAddress+uint64(keySize) > s.dataSize {
+		return false
+	}
+	return bytes.Equal(s.data[keyAddress:keyAddress+uint64(keySize)], key)
+}
+
+// checksum computes CRC32C of entry header fields, key and value
+func (s *segment) checksum(headerAddress, entryAddressEnd uint64) uint32 {
+	result := crc32.Update(0, crc32cTable, s.data[headerAddress+sizeOffset:headerAddress+checksumOffset])
+	return crc32.Update(result, crc32cTable, s.data[headerAddress+headerSize:entryAddressEnd])
+}
+
+// flags returns entry flags
+func (s *segment) flags(headerAddress uint64) byte {
+	return s.data[headerAddress+flagsOffset]
+}
+
+// version returns entry version
+func (s *segment) version(headerAddress uint64) uint64 {
+	return binary.LittleEndian.Uint64(s.data[headerAddress+versionOffset : headerAddress+checksumOffset])
+}
+
+// expiry returns entry expiry unix nano time, zero if entry does not expire
+func (s *segment) expiry(headerAddress uint64) uint64 {
+	return binary.LittleEndian.Uint64(s.data[headerAddress+expiryOffset : headerAddress+keySizeOffset])
+}
+
+func (s *segment) delete(key []byte) {
 	shardedMap := s.getShardedMap()
-	if shardedMap.delete(key) {
-	updateKeys:
-		if keys := atomic.LoadUint32(&s.keys); keys > 1 {
-			if !atomic.CompareAndSwapUint32(&s.keys, keys, keys-1) {
-				goto updateKeys
-			}
-		}
+	if shardedMap.delete(key, s) {
+		s.removeKeys(1)
+	}
+}
+
+// deleteVersion deletes the key entry only if it stores the supplied version
+func (s *segment) deleteVersion(key []byte, version uint64) {
+	if s.getShardedMap().removeIf(key, s, func(headerAddress uint64) bool {
+		return s.version(headerAddress) == version
+	}) {
+		s.removeKeys(1)
+	}
+}
+
+// deleteAll deletes batch keys
+func (s *segment) deleteAll(batch *keyBatch) {
+	if deleted := s.getShardedMap().deleteAll(batch, s); deleted > 0 {
+		s.removeKeys(deleted)
+	}
+}
+
+// removeKeys decrements segment keys count
+func (s *segment) removeKeys(deleted int) {
+updateKeys:
+	keys := atomic.LoadUint32(&s.keys)
+	remaining := uint32(0)
+	if keys > uint32(deleted) {
+		remaining = keys - uint32(deleted)
+	}
+	if !atomic.CompareAndSwapUint32(&s.keys, keys, remaining) {
+		goto updateKeys
 	}
 }
 
 func (s *segment) getShardedMap() *shardedMap {
-	result := s.shardedMap
-	return result
+	return (*shardedMap)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&s.shardedMap))))
+}
+
+func (s *segment) setShardedMap(shardedMap *shardedMap) {
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&s.shardedMap)), unsafe.Pointer(shardedMap))
 }
 
-func (s *segment) set(key string, value []byte) ([]byte, bool) {
-	if maxEntries := s.config.MaxEntries; maxEntries > 0 && 1+int(atomic.LoadUint32(&s.keys)) > maxEntries {
+func (s *segment) set(key []byte, value []byte, expiry uint64) ([]byte, bool) {
+	return s.setEntry(key, value, expiry, 0, 0)
+}
+
+func (s *segment) setEntry(key []byte, value []byte, expiry uint64, flags byte, version uint64) ([]byte, bool) {
+	if flags == 0 && !s.config.Checksum {
+		if entry, ok := s.overwrite(key, value, expiry, version); ok {
+			return entry, true
+		}
+	}
+	if !s.canAdd(1) || len(key) > maxKeySize {
 		return nil, false
 	}
 	shardedMap := s.getShardedMap()
-	blobSize := len(value) + headerSize
-	alignBlobSize := ((blobSize >> 5) + 1) << 5
-	nextAddress := int(atomic.AddUint64(&s.tail, uint64(alignBlobSize)))
+	headerAddress, ok := s.reserve(s.config.alignSize(valueOffset(len(key), flags) + len(value)))
+	if !ok {
+		return nil, false
+	}
+	entry := s.writeEntry(headerAddress, key, value, expiry, flags, version)
+	if hadKey := shardedMap.put(key, uint32(headerAddress>>s.config.alignmentShift), s); !hadKey {
+		atomic.AddUint32(&s.keys, 1)
+	}
+	return entry, true
+}
+
+// overwrite writes value in place of the key entry if it fits the entry aligned block, entry value is not leased
+// and was not handed out by Get or MGet, the key shard write lock serializes in place writers with readers
+// leasing or marking entry shared, entries with checksum are never overwritten as concurrent readers could observe checksum mismatch
+func (s *segment) overwrite(key []byte, value []byte, expiry uint64, version uint64) (entry []byte, ok bool) {
+	s.getShardedMap().update(key, s, func(headerAddress uint64) uint32 {
+		if headerAddress == 0 || s.flags(headerAddress) != 0 || s.isShared(headerAddress) {
+			return 0
+		}
+		offset := valueOffset(len(key), 0)
+		size := int(binary.LittleEndian.Uint32(s.data[headerAddress+sizeOffset : headerAddress+expiryOffset]))
+		if s.config.alignSize(offset+len(value)) > s.config.alignSize(offset+size) {
+			return 0
+		}
+		entryAddress := int(headerAddress) + offset
+		entry = s.data[entryAddress : entryAddress+len(value)]
+		copy(entry, value)
+		binary.LittleEndian.PutUint64(s.data[headerAddress+expiryOffset:headerAddress+keySizeOffset], expiry)
+		binary.LittleEndian.PutUint64(s.data[headerAddress+versionOffset:headerAddress+checksumOffset], version)
+		binary.LittleEndian.PutUint32(s.data[headerAddress+sizeOffset:headerAddress+expiryOffset], uint32(len(value)))
+		ok = true
+		return 0
+	})
+	return entry, ok
+}
+
+// setIf atomically writes entry when accept function, called with the key header address (zero if missing), returns true
+// together with the entry version, it returns false accepted flag when entry was rejected, and false ok flag when segment is full
+func (s *segment) setIf(key []byte, value []byte, expiry uint64, flags byte, accept func(headerAddress uint64) (uint64, bool)) (accepted bool, ok bool) {
+	if !s.canAdd(1) || len(key) > maxKeySize {
+		return true, false
+	}
+	stored, hadKey := s.getShardedMap().update(key, s, func(headerAddress uint64) uint32 {
+		var version uint64
+		if version, accepted = accept(headerAddress); !accepted {
+			return 0
+		}
+		entryAddress, reserved := s.reserve(s.config.alignSize(valueOffset(len(key), flags) + len(value)))
+		if !reserved {
+			return 0
+		}
+		s.writeEntry(entryAddress, key, value, expiry, flags, version)
+		return uint32(entryAddress >> s.config.alignmentShift)
+	})
+	if stored && !hadKey {
+		atomic.AddUint32(&s.keys, 1)
+	}
+	return accepted, stored
+}
 
+// canAdd checks if supplied number of keys can be added without exceeding max entries
+func (s *segment) canAdd(keys int) bool {
+	maxEntries := s.config.MaxEntries
+	return maxEntries == 0 || keys+int(atomic.LoadUint32(&s.keys)) <= maxEntries
+}
+
+// reserve allocates aligned space at the segment tail, it returns false when segment is out of memory
+func (s *segment) reserve(size int) (int, bool) {
+	nextAddress := int(atomic.AddUint64(&s.tail, uint64(size)))
 	if nextAddress >= len(s.data) { //out of memory,
 		atomic.SwapUint64(&s.tail, s.dataSize-1)
-		return nil, false
+		return 0, false
 	}
-	headerAddress := nextAddress - alignBlobSize
+	return nextAddress - size, true
+}
+
+// setAll writes batch entries into space reserved with a single tail update and puts their addresses to keys index,
+// entries get consecutive versions starting with the supplied one, it returns false when the segment can not fit all the entries
+func (s *segment) setAll(batch *keyBatch, values [][]byte, flags []byte, expiry uint64, version uint64) bool {
+	if !s.canAdd(len(batch.keys)) {
+		return false
+	}
+	batchSize := 0
+	for i, key := range batch.keys {
+		if len(key) > maxKeySize {
+			return false
+		}
+		batchSize += s.config.alignSize(valueOffset(len(key), flags[i]) + len(values[i]))
+	}
+	shardedMap := s.getShardedMap()
+	headerAddress, ok := s.reserve(batchSize)
+	if !ok {
+		return false
+	}
+	addresses := make([]uint32, len(batch.keys))
+	for i, key := range batch.keys {
+		s.writeEntry(headerAddress, key, values[i], expiry, flags[i], version+uint64(i))
+		addresses[i] = uint32(headerAddress >> s.config.alignmentShift)
+		headerAddress += s.config.alignSize(valueOffset(len(key), flags[i]) + len(values[i]))
+	}
+	if added := shardedMap.putAll(batch, addresses, s); added > 0 {
+		atomic.AddUint32(&s.keys, uint32(added))
+	}
+	return true
+}
+
+// writeEntry writes entry header, key and value at the supplied address, it returns entry value
+func (s *segment) writeEntry(headerAddress int, key []byte, value []byte, expiry uint64, flags byte, version uint64) []byte {
 	s.data[headerAddress] = controlByte
-	binary.LittleEndian.PutUint32(s.data[headerAddress+1:headerAddress+headerSize], uint32(len(value)))
-	entryAddress := headerAddress + headerSize
+	binary.LittleEndian.PutUint32(s.data[headerAddress+sizeOffset:headerAddress+expiryOffset], uint32(len(value)))
+	binary.LittleEndian.PutUint64(s.data[headerAddress+expiryOffset:headerAddress+keySizeOffset], expiry)
+	binary.LittleEndian.PutUint16(s.data[headerAddress+keySizeOffset:headerAddress+flagsOffset], uint16(len(key)))
+	s.data[headerAddress+flagsOffset] = flags
+	binary.LittleEndian.PutUint64(s.data[headerAddress+versionOffset:headerAddress+checksumOffset], version)
+	keyAddress := headerAddress + headerSize
+	copy(s.data[keyAddress:keyAddress+len(key)], key)
+	entryAddress := headerAddress + valueOffset(len(key), flags)
 	entryAddressOffset := entryAddress + len(value)
 	copy(s.data[entryAddress:entryAddressOffset], value)
-	if hadKey := shardedMap.put(key, uint32(headerAddress>>5)); !hadKey {
-		atomic.AddUint32(&s.keys, 1)
+	checksum := uint32(0)
+	if s.config.Checksum && flags&counterEntry == 0 {
+		checksum = s.checksum(uint64(headerAddress), uint64(entryAddressOffset))
 	}
-	return s.data[entryAddress:entryAddressOffset], true
+	binary.LittleEndian.PutUint32(s.data[headerAddress+checksumOffset:headerAddress+headerSize], checksum)
+	return s.data[entryAddress:entryAddressOffset]
 }
 
 func (s *segment) allocate(idx int) error {
 	s.index = uint32(idx)
-	s.tail = 32
+	s.tail = uint64(s.config.Alignment)
 	segmentDataSize := s.config.SegmentDataSize()
 	if s.config.Location == "" {
 		s.data = make([]byte, segmentDataSize)
 		s.dataSize = uint64(segmentDataSize)
 		return nil
 	}
-	s.mmap = newMmap(s.config.Location, s.config.SizeMb*mb)
+	s.mmap = newMmap(s.config.Location, fileHeaderSize+s.config.SizeMb*mb)
 	err := s.mmap.open()
 	if err == nil {
 		s.mmap.size = segmentDataSize
-		offset := int64(idx * segmentDataSize)
+		offset := int64(fileHeaderSize + idx*segmentDataSize)
 		err = s.mmap.assign(offset, &s.data)
 		s.dataSize = uint64(len(s.data))
 	}
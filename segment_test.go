@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSegment_get(t *testing.T) {
@@ -61,17 +63,17 @@ func TestSegment_get(t *testing.T) {
 		}
 		for i := 0; i < useCase.keys; i++ {
 			key := fmt.Sprintf("key%v", i)
-			_, has := segment.get(key)
+			_, has := segment.get([]byte(key))
 			if !assert.False(t, has, useCase.description) {
 			}
 			data := strings.Repeat(useCase.pattern, useCase.entrySize/2)
-			_, added := segment.set(key, []byte(data))
+			_, added := segment.set([]byte(key), []byte(data), 0)
 			if !assert.True(t, added, useCase.description) {
 				panic(1)
 
 			}
 
-			actual, has := segment.get(key)
+			actual, has := segment.get([]byte(key))
 			assert.True(t, has, useCase.description)
 
 			assert.EqualValues(t, data, string(actual), useCase.description)
@@ -79,11 +81,243 @@ func TestSegment_get(t *testing.T) {
 
 		for i := 0; i < useCase.keys; i++ {
 			key := fmt.Sprintf("key%v", i)
-			_, has := segment.get(key)
+			_, has := segment.get([]byte(key))
 			assert.True(t, has, useCase.description)
-			segment.delete(key)
-			_, has = segment.get(key)
+			segment.delete([]byte(key))
+			_, has = segment.get([]byte(key))
 			assert.False(t, has, useCase.description)
 		}
 	}
 }
+
+func TestSegment_hashCollision(t *testing.T) {
+	config := &Config{SizeMb: 1}
+	config.Init()
+	segment := &segment{
+		config:     config,
+		shardedMap: newShardedMap(config),
+	}
+	if !assert.Nil(t, segment.allocate(0)) {
+		return
+	}
+	_, added := segment.set([]byte("a"), []byte("value a"), 0)
+	assert.True(t, added)
+	address := segment.shardedMap.getAddress([]byte("a"), segment)
+	assert.True(t, address > 0)
+
+	//simulate hash collision: key "b" hash points to entry of key "a"
+	hashedKey := segment.shardedMap.hasher.Sum64([]byte("b"))
+	index := hashedKey & segment.shardedMap.shardsHash
+	segment.shardedMap.maps[index].Put(hashedKey, uint32(address>>config.alignmentShift))
+
+	_, has := segment.get([]byte("b"))
+	assert.False(t, has, "colliding key should not return other key value")
+
+	_, added = segment.set([]byte("b"), []byte("value b"), 0)
+	assert.True(t, added)
+	actual, has := segment.get([]byte("b"))
+	assert.True(t, has)
+	assert.EqualValues(t, "value b", string(actual))
+	actual, has = segment.get([]byte("a"))
+	assert.True(t, has)
+	assert.EqualValues(t, "value a", string(actual))
+
+	//deleting the colliding key does not break probe sequence
+	segment.shardedMap.maps[index].Put(hashedKey, 0)
+	actual, has = segment.get([]byte("b"))
+	assert.True(t, has)
+	assert.EqualValues(t, "value b", string(actual))
+	segment.delete([]byte("b"))
+	_, has = segment.get([]byte("b"))
+	assert.False(t, has)
+}
+
+func TestSegment_overwrite(t *testing.T) {
+	var useCases = []struct {
+		description string
+		config      *Config
+		values      []string
+		lease       bool
+		entryLease  bool
+		shared      bool
+		inPlace     bool
+	}{
+		{
+			description: "same size value",
+			config:      &Config{SizeMb: 1},
+			values:      []string{"value 1", "value 2"},
+			inPlace:     true,
+		},
+		{
+			description: "smaller value",
+			config:      &Config{SizeMb: 1},
+			values:      []string{"value 1", "v2", "value 3"},
+			inPlace:     true,
+		},
+		{
+			description: "larger value",
+			config:      &Config{SizeMb: 1},
+			values:      []string{"value 1", strings.Repeat("value 2", 10)},
+		},
+		{
+			description: "other value read under segment lease",
+			config:      &Config{SizeMb: 1},
+			values:      []string{"value 1", "value 2"},
+			lease:       true,
+			inPlace:     true,
+		},
+		{
+			description: "value read under entry lease",
+			config:      &Config{SizeMb: 1},
+			values:      []string{"value 1", "value 2"},
+			entryLease:  true,
+		},
+		{
+			description: "value returned without lease",
+			config:      &Config{SizeMb: 1},
+			values:      []string{"value 1", "value 2"},
+			shared:      true,
+		},
+		{
+			description: "checksum",
+			config:      &Config{SizeMb: 1, Checksum: true},
+			values:      []string{"value 1", "value 2"},
+		},
+	}
+
+	for _, useCase := range useCases {
+		useCase.config.Init()
+		segment := &segment{config: useCase.config, shardedMap: newShardedMap(useCase.config)}
+		if !assert.Nil(t, segment.allocate(0), useCase.description) {
+			continue
+		}
+		key := []byte("key")
+		_, added := segment.set(key, []byte(useCase.values[0]), 0)
+		assert.True(t, added, useCase.description)
+		tail := segment.tail
+		if useCase.lease {
+			assert.True(t, segment.acquire(), useCase.description)
+		}
+		var leased []byte
+		var leasedAddress uint64
+		if useCase.entryLease {
+			leasedAddress, leased, _ = segment.leaseEntry(key)
+		}
+		var shared []byte
+		if useCase.shared {
+			_, shared, _ = segment.lookupShared(key)
+		}
+		for _, value := range useCase.values[1:] {
+			_, added = segment.set(key, []byte(value), 0)
+			assert.True(t, added, useCase.description)
+			actual, has := segment.get(key)
+			assert.True(t, has, useCase.description)
+			assert.EqualValues(t, value, string(actual), useCase.description)
+		}
+		if useCase.lease {
+			segment.release()
+		}
+		if useCase.entryLease {
+			assert.EqualValues(t, useCase.values[0], string(leased), useCase.description)
+			segment.releaseEntry(leasedAddress)
+		}
+		if useCase.shared {
+			assert.EqualValues(t, useCase.values[0], string(shared), useCase.description)
+		}
+		assert.Equal(t, useCase.inPlace, tail == segment.tail, useCase.description)
+		assert.EqualValues(t, 1, segment.keys, useCase.description)
+	}
+}
+
+func TestSegment_recycle(t *testing.T) {
+	config := &Config{SizeMb: 1}
+	config.Init()
+	segment := &segment{config: config, shardedMap: newShardedMap(config)}
+	if !assert.Nil(t, segment.allocate(0)) {
+		return
+	}
+	_, added := segment.set([]byte("key"), []byte("value"), 0)
+	assert.True(t, added)
+	assert.True(t, segment.acquire())
+	assert.True(t, segment.acquire())
+	recycled := make(chan struct{})
+	go func() {
+		segment.reset()
+		close(recycled)
+	}()
+	for atomic.LoadInt32(&segment.recycling) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(t, segment.acquire(), "segment being recycled can not be acquired")
+	segment.release()
+	select {
+	case <-recycled:
+		assert.Fail(t, "recycle should wait for all readers")
+	case <-time.After(10 * time.Millisecond):
+	}
+	segment.release()
+	<-recycled
+	assert.EqualValues(t, config.Alignment, segment.tail)
+	assert.EqualValues(t, 0, segment.keys)
+	assert.True(t, segment.acquire())
+	segment.release()
+}
+
+func TestSegment_alignment(t *testing.T) {
+	var useCases = []struct {
+		description string
+		alignment   int
+		key         string
+		value       string
+		expectSize  uint64
+	}{
+		{
+			description: "8 bytes alignment",
+			alignment:   8,
+			key:         "k1",
+			value:       "12345678",
+			expectSize:  40,
+		},
+		{
+			description: "default alignment",
+			key:         "k1",
+			value:       "12345678",
+			expectSize:  64,
+		},
+		{
+			description: "already aligned size",
+			alignment:   16,
+			key:         "k1",
+			value:       "1",
+			expectSize:  32,
+		},
+		{
+			description: "64 bytes alignment",
+			alignment:   64,
+			key:         "key1",
+			value:       "12345678",
+			expectSize:  64,
+		},
+	}
+
+	for _, useCase := range useCases {
+		config := &Config{SizeMb: 1, Alignment: useCase.alignment}
+		config.Init()
+		segment := &segment{config: config, shardedMap: newShardedMap(config)}
+		if !assert.Nil(t, segment.allocate(0), useCase.description) {
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			tail := segment.tail
+			key := []byte(fmt.Sprintf("%v%v", useCase.key, i))
+			_, added := segment.set(key, []byte(useCase.value), 0)
+			assert.True(t, added, useCase.description)
+			assert.EqualValues(t, useCase.expectSize, segment.tail-tail, useCase.description)
+			actual, has := segment.get(key)
+			assert.True(t, has, useCase.description)
+			assert.EqualValues(t, useCase.value, string(actual), useCase.description)
+		}
+	}
+	_, err := New(&Config{Alignment: 12})
+	assert.NotNil(t, err)
+}
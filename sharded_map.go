@@ -5,61 +5,249 @@ import (
 	"sync"
 )
 
-// shardedMap represents sharded map
+// keyMatcher checks if entry stored at the header address belongs to the supplied key
+type keyMatcher interface {
+	matchKey(headerAddress uint64, key []byte) bool
+}
+
+// shardedMap represents sharded map, hash collisions are resolved by probing subsequent hash values within the same shard,
+// deleted entries keep zero address to preserve probing sequence
 type shardedMap struct {
 	config     Config
 	lock       []sync.RWMutex
 	maps       []*swiss.Map[uint64, uint32]
-	hasher     fnv64a
+	hasher     Hasher
 	shardsHash uint64
+	//shift converts entry aligned address to header address
+	shift uint
 }
 
-func (m *shardedMap) getAddress(key string) uint64 {
+func (m *shardedMap) getAddress(key []byte, matcher keyMatcher) uint64 {
 	hashedKey := m.hasher.Sum64(key)
 	index := hashedKey & m.shardsHash
 	m.lock[index].RLock()
-	if m.maps[index].Count() == 0 {
-		m.lock[index].RUnlock()
-		return 0
+	address := m.find(m.maps[index], hashedKey, key, matcher)
+	m.lock[index].RUnlock()
+	return address
+}
+
+// tryGetAddress returns the key header address, it returns false without waiting if the key shard is locked for writing
+func (m *shardedMap) tryGetAddress(key []byte, matcher keyMatcher) (uint64, bool) {
+	hashedKey := m.hasher.Sum64(key)
+	index := hashedKey & m.shardsHash
+	if !m.lock[index].TryRLock() {
+		return 0, false
 	}
-	value, _ := m.maps[index].Get(hashedKey)
+	address := m.find(m.maps[index], hashedKey, key, matcher)
 	m.lock[index].RUnlock()
-	return uint64(value) << 5
+	return address, true
 }
 
-func (m *shardedMap) put(key string, value uint32) bool {
+func (m *shardedMap) put(key []byte, value uint32, matcher keyMatcher) bool {
 	hashedKey := m.hasher.Sum64(key)
 	index := hashedKey & m.shardsHash
 	m.lock[index].Lock()
-	_, has := m.maps[index].Get(hashedKey)
-	m.maps[index].Put(hashedKey, value)
+	hadKey := m.store(m.maps[index], hashedKey, key, value, matcher)
 	m.lock[index].Unlock()
-	return has
+	return hadKey
 }
 
-func (m *shardedMap) delete(key string) bool {
+func (m *shardedMap) delete(key []byte, matcher keyMatcher) bool {
 	hashedKey := m.hasher.Sum64(key)
 	index := hashedKey & m.shardsHash
 	m.lock[index].Lock()
-	if m.maps[index].Count() == 0 {
-		m.lock[index].Unlock()
+	deleted := m.remove(m.maps[index], hashedKey, key, matcher)
+	m.lock[index].Unlock()
+	return deleted
+}
+
+// read calls fn with the key header address (zero if missing) under shard read lock
+func (m *shardedMap) read(key []byte, matcher keyMatcher, fn func(headerAddress uint64)) {
+	hashedKey := m.hasher.Sum64(key)
+	index := hashedKey & m.shardsHash
+	m.lock[index].RLock()
+	defer m.lock[index].RUnlock()
+	fn(m.find(m.maps[index], hashedKey, key, matcher))
+}
+
+// tryRead calls fn with the key header address under shard read lock, it returns false without calling fn if shard is locked for writing
+func (m *shardedMap) tryRead(key []byte, matcher keyMatcher, fn func(headerAddress uint64)) bool {
+	hashedKey := m.hasher.Sum64(key)
+	index := hashedKey & m.shardsHash
+	if !m.lock[index].TryRLock() {
 		return false
 	}
-	value, _ := m.maps[index].Get(hashedKey)
-	m.maps[index].Put(hashedKey, 0)
-	m.lock[index].Unlock()
-	return value > 0
+	defer m.lock[index].RUnlock()
+	fn(m.find(m.maps[index], hashedKey, key, matcher))
+	return true
+}
+
+// removeIf removes the key under shard write lock if fn called with the key header address returns true
+func (m *shardedMap) removeIf(key []byte, matcher keyMatcher, fn func(headerAddress uint64) bool) bool {
+	hashedKey := m.hasher.Sum64(key)
+	index := hashedKey & m.shardsHash
+	m.lock[index].Lock()
+	defer m.lock[index].Unlock()
+	aMap := m.maps[index]
+	if address := m.find(aMap, hashedKey, key, matcher); address == 0 || !fn(address) {
+		return false
+	}
+	return m.remove(aMap, hashedKey, key, matcher)
+}
+
+// update calls fn with the key header address (zero if missing) under shard write lock, non zero address returned by fn
+// is stored for the key, it returns true if address was stored and true if key had been stored before
+func (m *shardedMap) update(key []byte, matcher keyMatcher, fn func(headerAddress uint64) uint32) (bool, bool) {
+	hashedKey := m.hasher.Sum64(key)
+	index := hashedKey & m.shardsHash
+	m.lock[index].Lock()
+	defer m.lock[index].Unlock()
+	aMap := m.maps[index]
+	value := fn(m.find(aMap, hashedKey, key, matcher))
+	if value == 0 {
+		return false, false
+	}
+	return true, m.store(aMap, hashedKey, key, value, matcher)
+}
+
+// find returns header address of the entry storing the key, caller holds the shard lock
+func (m *shardedMap) find(aMap *swiss.Map[uint64, uint32], hashedKey uint64, key []byte, matcher keyMatcher) uint64 {
+	if aMap.Count() == 0 {
+		return 0
+	}
+	for probe := hashedKey; ; probe++ {
+		value, ok := aMap.Get(probe)
+		if !ok {
+			return 0
+		}
+		if value == 0 {
+			continue
+		}
+		if address := uint64(value) << m.shift; matcher.matchKey(address, key) {
+			return address
+		}
+	}
+}
+
+// store puts key address into the first free probing slot unless the key is already stored, caller holds the shard lock
+func (m *shardedMap) store(aMap *swiss.Map[uint64, uint32], hashedKey uint64, key []byte, value uint32, matcher keyMatcher) bool {
+	slot, hasSlot := uint64(0), false
+	for probe := hashedKey; ; probe++ {
+		address, ok := aMap.Get(probe)
+		if !ok {
+			if !hasSlot {
+				slot = probe
+			}
+			break
+		}
+		if address == 0 {
+			if !hasSlot {
+				slot, hasSlot = probe, true
+			}
+			continue
+		}
+		if matcher.matchKey(uint64(address)<<m.shift, key) {
+			aMap.Put(probe, value)
+			return true
+		}
+	}
+	aMap.Put(slot, value)
+	return false
+}
+
+// remove zeroes the key address keeping probing sequence intact, caller holds the shard lock
+func (m *shardedMap) remove(aMap *swiss.Map[uint64, uint32], hashedKey uint64, key []byte, matcher keyMatcher) bool {
+	if aMap.Count() == 0 {
+		return false
+	}
+	for probe := hashedKey; ; probe++ {
+		address, ok := aMap.Get(probe)
+		if !ok {
+			return false
+		}
+		if address == 0 {
+			continue
+		}
+		if matcher.matchKey(uint64(address)<<m.shift, key) {
+			aMap.Put(probe, 0)
+			return true
+		}
+	}
+}
+
+// getAddresses sets header addresses of the batch keys not yet resolved, each shard is read locked once,
+// found function is called with each found address under the shard read lock
+func (m *shardedMap) getAddresses(batch *keyBatch, resolved []bool, addresses []uint64, matcher keyMatcher, found func(headerAddress uint64)) {
+	batch.shards(func(index uint64, positions []int) {
+		aMap := m.maps[index]
+		m.lock[index].RLock()
+		for _, i := range positions {
+			if !resolved[i] {
+				if addresses[i] = m.find(aMap, batch.hashes[i], batch.keys[i], matcher); addresses[i] != 0 {
+					found(addresses[i])
+				}
+			}
+		}
+		m.lock[index].RUnlock()
+	})
+}
+
+// putAll puts batch keys addresses, each shard is locked once, it returns number of keys not stored before
+func (m *shardedMap) putAll(batch *keyBatch, values []uint32, matcher keyMatcher) int {
+	added := 0
+	batch.shards(func(index uint64, positions []int) {
+		aMap := m.maps[index]
+		m.lock[index].Lock()
+		for _, i := range positions {
+			if !m.store(aMap, batch.hashes[i], batch.keys[i], values[i], matcher) {
+				added++
+			}
+		}
+		m.lock[index].Unlock()
+	})
+	return added
+}
+
+// deleteAll deletes batch keys, each shard is locked once, it returns number of deleted keys
+func (m *shardedMap) deleteAll(batch *keyBatch, matcher keyMatcher) int {
+	deleted := 0
+	batch.shards(func(index uint64, positions []int) {
+		aMap := m.maps[index]
+		m.lock[index].Lock()
+		for _, i := range positions {
+			if m.remove(aMap, batch.hashes[i], batch.keys[i], matcher) {
+				deleted++
+			}
+		}
+		m.lock[index].Unlock()
+	})
+	return deleted
+}
+
+// clear removes all keys from all shards
+func (m *shardedMap) clear() {
+	for i := range m.maps {
+		m.lock[i].Lock()
+		clearSwissMap(m.maps[i], keys, values)
+		m.lock[i].Unlock()
+	}
 }
 
 func newShardedMap(config *Config) *shardedMap {
 	if config.Shards == 0 {
 		config.Shards = 100
 	}
+	hasher := config.hasher
+	if hasher == nil {
+		hasher = newDefaultHasher()
+	}
 	aMap := &shardedMap{
+		hasher:     hasher,
 		config:     *config,
 		lock:       make([]sync.RWMutex, config.Shards),
 		maps:       make([]*swiss.Map[uint64, uint32], config.Shards),
 		shardsHash: config.Shards - 1,
+		shift:      config.alignmentShift,
 	}
 	for i := range aMap.maps {
 		aMap.maps[i] = swiss.NewMap[uint64, uint32](uint32(config.shardMapSize))
@@ -0,0 +1,96 @@
+package scache
+
+import "sync/atomic"
+
+//Stats represents cache runtime statistics snapshot
+type Stats struct {
+	Gets          uint64 //number of get operations
+	PrimaryHits   uint64 //number of keys found in the primary segment
+	SecondaryHits uint64 //number of keys found in the secondary segment and promoted to the primary one
+	Misses        uint64 //number of keys not found
+	Sets          uint64 //number of successful set operations
+	FailedSets    uint64 //number of failed set operations
+	Deletes       uint64 //number of delete operations
+	Switches      uint64 //number of segment switches
+	BytesWritten  uint64 //number of value bytes written by successful set operations
+	Corrupted     uint64 //number of entries failing checksum verification
+	Segments      []SegmentStats
+}
+
+//SegmentStats represents segment statistics snapshot
+type SegmentStats struct {
+	Index  uint32 //segment index
+	Active bool   //true for the primary segment
+	Keys   uint32 //number of keys in the segment
+	Tail   uint64 //data size used by the segment
+	Size   uint64 //segment data size
+}
+
+//HitRatio returns ratio of hits to gets
+func (s *Stats) HitRatio() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.PrimaryHits+s.SecondaryHits) / float64(s.Gets)
+}
+
+type counters struct {
+	gets          uint64
+	primaryHits   uint64
+	secondaryHits uint64
+	misses        uint64
+	sets          uint64
+	failedSets    uint64
+	deletes       uint64
+	switches      uint64
+	bytesWritten  uint64
+}
+
+//Stats returns cache statistics snapshot
+func (s *Cache) Stats() Stats {
+	result := Stats{
+		Gets:          atomic.LoadUint64(&s.counters.gets),
+		PrimaryHits:   atomic.LoadUint64(&s.counters.primaryHits),
+		SecondaryHits: atomic.LoadUint64(&s.counters.secondaryHits),
+		Misses:        atomic.LoadUint64(&s.counters.misses),
+		Sets:          atomic.LoadUint64(&s.counters.sets),
+		FailedSets:    atomic.LoadUint64(&s.counters.failedSets),
+		Deletes:       atomic.LoadUint64(&s.counters.deletes),
+		Switches:      atomic.LoadUint64(&s.counters.switches),
+		BytesWritten:  atomic.LoadUint64(&s.counters.bytesWritten),
+		Segments:      make([]SegmentStats, len(s.segments)),
+	}
+	idx := atomic.LoadUint32(&s.index)
+	for i := range s.segments {
+		segment := &s.segments[i]
+		result.Corrupted += atomic.LoadUint64(&segment.corrupted)
+		tail := atomic.LoadUint64(&segment.tail)
+		if tail > segment.dataSize {
+			tail = segment.dataSize
+		}
+		result.Segments[i] = SegmentStats{
+			Index:  uint32(i),
+			Active: uint32(i) == idx,
+			Keys:   atomic.LoadUint32(&segment.keys),
+			Tail:   tail,
+			Size:   segment.dataSize,
+		}
+	}
+	return result
+}
+
+//ResetStats resets cache statistics counters
+func (s *Cache) ResetStats() {
+	atomic.StoreUint64(&s.counters.gets, 0)
+	atomic.StoreUint64(&s.counters.primaryHits, 0)
+	atomic.StoreUint64(&s.counters.secondaryHits, 0)
+	atomic.StoreUint64(&s.counters.misses, 0)
+	atomic.StoreUint64(&s.counters.sets, 0)
+	atomic.StoreUint64(&s.counters.failedSets, 0)
+	atomic.StoreUint64(&s.counters.deletes, 0)
+	atomic.StoreUint64(&s.counters.switches, 0)
+	atomic.StoreUint64(&s.counters.bytesWritten, 0)
+	for i := range s.segments {
+		atomic.StoreUint64(&s.segments[i].corrupted, 0)
+	}
+}
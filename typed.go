@@ -0,0 +1,256 @@
+package scache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/viant/xunsafe"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// KeyFormatter appends typed key cache representation to dst
+type KeyFormatter[K any] func(dst []byte, key K) []byte
+
+// StringKey formats string key as is
+func StringKey[K ~string](dst []byte, key K) []byte {
+	return append(dst, key...)
+}
+
+// IntKey formats signed integer key in decimal
+func IntKey[K ~int | ~int8 | ~int16 | ~int32 | ~int64](dst []byte, key K) []byte {
+	return strconv.AppendInt(dst, int64(key), 10)
+}
+
+// UintKey formats unsigned integer key in decimal
+func UintKey[K ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64](dst []byte, key K) []byte {
+	return strconv.AppendUint(dst, uint64(key), 10)
+}
+
+// AnyKey formats key with fmt default format, use it for composite keys which format is unique per key,
+// keys are not namespaced by type, thus keys of different types with the same format, e.g. "1" and 1, collide
+func AnyKey[K any](dst []byte, key K) []byte {
+	return fmt.Append(dst, key)
+}
+
+// ValueCodec represents typed value codec, implementation has to be safe for concurrent use
+type ValueCodec[V any] interface {
+	//Encode appends encoded value to dst
+	Encode(dst []byte, value *V) ([]byte, error)
+	//Decode decodes data into value, data is only valid during the call
+	Decode(data []byte, value *V) error
+}
+
+// ValueViewer represents value codec able to expose encoded data as value without decoding
+type ValueViewer[V any] interface {
+	//View returns value backed by data memory, it returns false if data can not back the value
+	View(data []byte) (*V, bool)
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec[V any] struct{}
+
+// Encode appends JSON encoded value to dst
+func (JSONCodec[V]) Encode(dst []byte, value *V) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
+
+// Decode decodes JSON data into value
+func (JSONCodec[V]) Decode(data []byte, value *V) error {
+	return json.Unmarshal(data, value)
+}
+
+// GobCodec encodes values with encoding/gob, each value carries its type description
+type GobCodec[V any] struct{}
+
+// Encode appends gob encoded value to dst
+func (GobCodec[V]) Encode(dst []byte, value *V) ([]byte, error) {
+	buffer := bytes.NewBuffer(dst)
+	if err := gob.NewEncoder(buffer).Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decode decodes gob data into value
+func (GobCodec[V]) Decode(data []byte, value *V) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// BinaryCodec encodes fixed size values with encoding/binary little endian byte order
+type BinaryCodec[V any] struct{}
+
+// Encode appends binary encoded value to dst
+func (BinaryCodec[V]) Encode(dst []byte, value *V) ([]byte, error) {
+	buffer := bytes.NewBuffer(dst)
+	if err := binary.Write(buffer, binary.LittleEndian, value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decode decodes binary data into value
+func (BinaryCodec[V]) Decode(data []byte, value *V) error {
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, value)
+}
+
+// UnsafeCodec copies fixed layout value memory as is, so that neither encoding nor decoding allocates or reflects,
+// Typed.View exposes aligned segment data as value without copying, value layout depends on the platform,
+// thus memory mapped file data is only portable between the same architectures
+type UnsafeCodec[V any] struct {
+	size  int
+	align uintptr
+}
+
+// Encode appends value memory to dst
+func (c *UnsafeCodec[V]) Encode(dst []byte, value *V) ([]byte, error) {
+	return append(dst, unsafe.Slice((*byte)(unsafe.Pointer(value)), c.size)...), nil
+}
+
+// Decode copies data into value memory
+func (c *UnsafeCodec[V]) Decode(data []byte, value *V) error {
+	if len(data) != c.size {
+		return errors.Errorf("invalid %T data size: %v, expected: %v", value, len(data), c.size)
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(value)), c.size), data)
+	return nil
+}
+
+// View returns value backed by data memory, it returns false if data size does not match value size
+// or data address is not aligned for the value type
+func (c *UnsafeCodec[V]) View(data []byte) (*V, bool) {
+	if len(data) != c.size || c.size == 0 {
+		return nil, false
+	}
+	pointer := xunsafe.AsPointer(&data[0])
+	if uintptr(pointer)%c.align != 0 {
+		return nil, false
+	}
+	return (*V)(pointer), true
+}
+
+// NewUnsafeCodec creates unsafe codec, it returns error if value type contains pointers, strings, slices, maps or interfaces
+func NewUnsafeCodec[V any]() (*UnsafeCodec[V], error) {
+	var value V
+	valueType := reflect.TypeOf(&value).Elem()
+	if err := checkFixedLayout(valueType); err != nil {
+		return nil, err
+	}
+	return &UnsafeCodec[V]{size: int(valueType.Size()), align: uintptr(valueType.Align())}, nil
+}
+
+// checkFixedLayout checks if type memory holds the whole value
+func checkFixedLayout(aType reflect.Type) error {
+	switch aType.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return nil
+	case reflect.Array:
+		return checkFixedLayout(aType.Elem())
+	case reflect.Struct:
+		for _, field := range xunsafe.NewStruct(aType).Fields {
+			if err := checkFixedLayout(field.Type); err != nil {
+				return errors.Wrapf(err, "invalid field %v.%v", aType.Name(), field.Name)
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("unsupported fixed layout type: %v", aType)
+}
+
+// Typed represents type safe cache view, keys are formatted with key formatter and values encoded with value codec,
+// encoded values are stored in the underlying cache
+type Typed[K any, V any] struct {
+	cache   *Cache
+	key     KeyFormatter[K]
+	codec   ValueCodec[V]
+	buffers sync.Pool
+}
+
+// buffer returns pooled encoding buffer
+func (t *Typed[K, V]) buffer() *[]byte {
+	if buffer, ok := t.buffers.Get().(*[]byte); ok {
+		return buffer
+	}
+	buffer := make([]byte, 0, 256)
+	return &buffer
+}
+
+// Get returns decoded value for the supplied key, value is decoded directly from the segment data
+func (t *Typed[K, V]) Get(key K) (V, error) {
+	var value V
+	var err error
+	if viewErr := t.cache.view(t.key(nil, key), func(data []byte, _ uint64) {
+		err = t.codec.Decode(data, &value)
+	}, nil); viewErr != nil {
+		return value, viewErr
+	}
+	return value, err
+}
+
+// View calls fn with value for the supplied key, value of codec implementing ValueViewer is backed by the segment data
+// if the codec can view it, otherwise decoded value is supplied, value is only valid until fn returns,
+// fn must neither retain nor modify the value nor modify the cache
+func (t *Typed[K, V]) View(key K, fn func(value *V)) error {
+	viewer, _ := t.codec.(ValueViewer[V])
+	var err error
+	if viewErr := t.cache.view(t.key(nil, key), func(data []byte, _ uint64) {
+		if viewer != nil {
+			if value, ok := viewer.View(data); ok {
+				fn(value)
+				return
+			}
+		}
+		var value V
+		if err = t.codec.Decode(data, &value); err == nil {
+			fn(&value)
+		}
+	}, nil); viewErr != nil {
+		return viewErr
+	}
+	return err
+}
+
+// Set sets key with encoded value, entry expires after Config.DefaultTTL if specified
+func (t *Typed[K, V]) Set(key K, value V) error {
+	return t.SetWithTTL(key, value, t.cache.config.DefaultTTL)
+}
+
+// SetWithTTL sets key with encoded value and time to live, see Cache.SetWithTTL for zero and negative ttl
+func (t *Typed[K, V]) SetWithTTL(key K, value V, ttl time.Duration) error {
+	buffer := t.buffer()
+	defer t.buffers.Put(buffer)
+	keyData := t.key((*buffer)[:0], key) //key and encoded value share the buffer
+	data, err := t.codec.Encode(keyData, &value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %T", value)
+	}
+	*buffer = data[:0]
+	return t.cache.set(data[:len(keyData)], data[len(keyData):], ttl, 0)
+}
+
+// Delete deletes key in the cache
+func (t *Typed[K, V]) Delete(key K) error {
+	return t.cache.DeleteBytes(t.key(nil, key))
+}
+
+// Cache returns the underlying cache
+func (t *Typed[K, V]) Cache() *Cache {
+	return t.cache
+}
+
+// NewTyped creates type safe cache view with the supplied key formatter and value codec
+func NewTyped[K any, V any](cache *Cache, key KeyFormatter[K], codec ValueCodec[V]) *Typed[K, V] {
+	return &Typed[K, V]{cache: cache, key: key, codec: codec}
+}
@@ -0,0 +1,120 @@
+package scache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"unsafe"
+)
+
+type typedPoint struct {
+	X, Y  int64
+	Tags  [4]uint8
+	Valid bool
+}
+
+type typedUser struct {
+	ID   int
+	Name string
+}
+
+func TestTyped(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1})
+	if !assert.Nil(t, err) {
+		return
+	}
+	unsafeCodec, err := NewUnsafeCodec[typedPoint]()
+	if !assert.Nil(t, err) {
+		return
+	}
+	point := typedPoint{X: 1, Y: -2, Tags: [4]uint8{1, 2, 3, 4}, Valid: true}
+	var useCases = []struct {
+		description string
+		run         func() (interface{}, interface{}, error)
+	}{
+		{
+			description: "json codec with string key",
+			run: func() (interface{}, interface{}, error) {
+				typed := NewTyped[string, typedUser](cache, StringKey[string], JSONCodec[typedUser]{})
+				user := typedUser{ID: 1, Name: "json"}
+				if err := typed.Set("u1", user); err != nil {
+					return nil, nil, err
+				}
+				actual, err := typed.Get("u1")
+				return user, actual, err
+			},
+		},
+		{
+			description: "gob codec with int key",
+			run: func() (interface{}, interface{}, error) {
+				typed := NewTyped[int, typedUser](cache, IntKey[int], GobCodec[typedUser]{})
+				user := typedUser{ID: 2, Name: "gob"}
+				if err := typed.Set(-2, user); err != nil {
+					return nil, nil, err
+				}
+				actual, err := typed.Get(-2)
+				return user, actual, err
+			},
+		},
+		{
+			description: "binary codec with uint key",
+			run: func() (interface{}, interface{}, error) {
+				typed := NewTyped[uint32, typedPoint](cache, UintKey[uint32], BinaryCodec[typedPoint]{})
+				if err := typed.Set(3, point); err != nil {
+					return nil, nil, err
+				}
+				actual, err := typed.Get(3)
+				return point, actual, err
+			},
+		},
+		{
+			description: "unsafe codec with composite key",
+			run: func() (interface{}, interface{}, error) {
+				typed := NewTyped[[2]int, typedPoint](cache, AnyKey[[2]int], unsafeCodec)
+				if err := typed.Set([2]int{1, 2}, point); err != nil {
+					return nil, nil, err
+				}
+				actual, err := typed.Get([2]int{1, 2})
+				return point, actual, err
+			},
+		},
+	}
+
+	for _, useCase := range useCases {
+		expect, actual, err := useCase.run()
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, expect, actual, useCase.description)
+	}
+
+	typed := NewTyped[string, typedPoint](cache, StringKey[string], unsafeCodec)
+	assert.Nil(t, typed.Set("p", point))
+	assert.Nil(t, typed.Delete("p"))
+	_, err = typed.Get("p")
+	assert.True(t, isNoSuchKey(err))
+	assert.Nil(t, cache.Set("p", []byte("abc")))
+	_, err = typed.Get("p")
+	assert.NotNil(t, err, "invalid data size")
+
+	//unsafe codec view is backed by segment data if value address is aligned, key length 4 aligns value after 28 byte header
+	for _, key := range []string{"p123", "p"} {
+		assert.Nil(t, typed.Set(key, point), key)
+		var viewed *typedPoint
+		assert.Nil(t, typed.View(key, func(value *typedPoint) {
+			assert.EqualValues(t, point, *value, key)
+			viewed = value
+		}), key)
+		segment := &cache.segments[cache.index]
+		_, data, _ := segment.lookup([]byte(key))
+		assert.Equal(t, key == "p123", unsafe.Pointer(viewed) == unsafe.Pointer(&data[0]), key)
+	}
+	jsonTyped := NewTyped[string, typedUser](cache, StringKey[string], JSONCodec[typedUser]{})
+	assert.Nil(t, jsonTyped.Set("u", typedUser{ID: 3, Name: "view"}))
+	assert.Nil(t, jsonTyped.View("u", func(value *typedUser) {
+		assert.EqualValues(t, "view", value.Name)
+	}))
+	assert.True(t, isNoSuchKey(jsonTyped.View("missing", func(value *typedUser) {})))
+
+	_, err = NewUnsafeCodec[typedUser]()
+	assert.NotNil(t, err, "string field is not fixed layout")
+	_, err = NewUnsafeCodec[*typedPoint]()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,190 @@
+package scache
+
+import (
+	"github.com/pkg/errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// nextVersion reserves supplied number of consecutive entry versions and returns the first one
+func (s *Cache) nextVersion(count int) uint64 {
+	return atomic.AddUint64(&s.version, uint64(count)) - uint64(count) + 1
+}
+
+// GetWithVersion returns a cache entry with its version for the supplied key or error, entry version changes with every set,
+// see Get for returned value lifetime, use GetWithVersionInto or ViewWithVersion when value outlives segment switch
+func (s *Cache) GetWithVersion(key string) ([]byte, uint64, error) {
+	value, version, _, err := s.get(keyBytes(key))
+	return value, version, err
+}
+
+// GetWithVersionInto copies value for the supplied key into dst and returns it with the entry version,
+// dst is reallocated only if its capacity is not sufficient
+func (s *Cache) GetWithVersionInto(key string, dst []byte) ([]byte, uint64, error) {
+	var version uint64
+	err := s.ViewWithVersion(key, func(value []byte, entryVersion uint64) {
+		dst = append(dst[:0], value...)
+		version = entryVersion
+	})
+	return dst, version, err
+}
+
+// ViewWithVersion calls fn with value and version for the supplied key without copying value, see View for value lifetime
+func (s *Cache) ViewWithVersion(key string, fn func(value []byte, version uint64)) error {
+	return s.view(keyBytes(key), fn, nil)
+}
+
+// CompareAndSet sets key with value only if the current entry version matches the expected one, zero expected version
+// matches missing key, it returns the new entry version or VersionMismatch error, entry expires after Config.DefaultTTL if specified
+func (s *Cache) CompareAndSet(key string, expectedVersion uint64, value []byte) (uint64, error) {
+	return s.compareAndSet(keyBytes(key), expectedVersion, value, s.config.DefaultTTL)
+}
+
+// SetIfAbsent sets key with value only if the key is missing, it returns true if value was set
+func (s *Cache) SetIfAbsent(key string, value []byte) (bool, error) {
+	_, err := s.compareAndSet(keyBytes(key), 0, value, s.config.DefaultTTL)
+	if err != nil {
+		if _, ok := err.(*VersionMismatch); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// compareAndSet compares and sets entry in the primary segment under the key shard lock, so that concurrent writers
+// of the same key are serialized, older generations are checked under the same lock only if the primary segment
+// does not store the key, the attempt is retried if segments switched in the meantime or older generation key shard is locked,
+// large value is stored in the large object area upfront and published only when the pointer entry is accepted
+func (s *Cache) compareAndSet(key []byte, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, error) {
+	if !s.enter() {
+		return 0, ErrClosed
+	}
+	defer s.exit()
+	var large *largeObject
+	size := len(value)
+	flags := byte(0)
+	if s.large != nil && s.isLarge(key, len(value)) {
+		var onEvict func(key string, id uint64, value []byte)
+		if s.OnEvict != nil {
+			onEvict = s.notifyLargeEvict
+		}
+		var err error
+		if large, err = s.large.store(string(key), value, nil, onEvict); err != nil {
+			atomic.AddUint64(&s.counters.failedSets, 1)
+			return 0, errors.Wrapf(err, "failed to set key: %s", key)
+		}
+		value, flags = largePointer(large.id, large.size), largeEntry
+	} else {
+		value, flags = s.compress(value)
+		size = len(value)
+	}
+	if err := s.checkEntry(key, len(value), flags); err != nil {
+		if large != nil {
+			s.large.discard(large)
+		}
+		return 0, err
+	}
+	expiry := expiryTime(ttl)
+	for attempt := 0; attempt < 2; {
+		idx := atomic.LoadUint32(&s.index)
+		primary := &s.segments[idx]
+		var currentVersion, version uint64
+		var evicted []byte
+		stale, hasEvicted, lost := false, false, false
+		accepted, ok := primary.setIf(key, value, expiry, flags, func(headerAddress uint64) (uint64, bool) {
+			//segment switch since primary was loaded makes older generations versions stale, thus the attempt is retried
+			if stale = atomic.LoadUint32(&s.index) != idx; stale {
+				return 0, false
+			}
+			segment := primary
+			if headerAddress == 0 { //older generation is read under the primary key shard lock, so that concurrent compare and set sees this write
+				var locked bool
+				if segment, headerAddress, locked = s.olderEntry(idx, key); !locked {
+					stale = true
+					return 0, false
+				}
+			}
+			currentVersion = 0
+			if headerAddress != 0 {
+				currentVersion = entryVersion(segment, headerAddress)
+			}
+			if currentVersion != expectedVersion {
+				return 0, false
+			}
+			if s.OnEvict != nil && currentVersion != 0 { //replaced value is notified once the new one is set
+				if segment == primary {
+					evicted, hasEvicted = s.evictedValue(segment, key, headerAddress)
+				} else if segment.acquire() {
+					leasedAddress, locked := segment.tryLeaseEntry(key)
+					if leasedAddress != 0 {
+						evicted, hasEvicted = s.evictedValue(segment, key, leasedAddress)
+						segment.releaseEntry(leasedAddress)
+					}
+					segment.release()
+					if stale = !locked; stale {
+						return 0, false
+					}
+				}
+			}
+			if lost = large != nil && !s.large.publish(large); lost { //stored large value was evicted in the meantime
+				return 0, false
+			}
+			version = s.nextVersion(1)
+			return version, true
+		})
+		if stale {
+			runtime.Gosched()
+			continue
+		}
+		if lost {
+			break
+		}
+		if !accepted {
+			if large != nil {
+				s.large.discard(large)
+			}
+			return 0, &VersionMismatch{Expected: expectedVersion, Actual: currentVersion}
+		}
+		if ok {
+			if hasEvicted {
+				s.OnEvict(string(key), evicted, EvictOverwrite)
+			}
+			atomic.AddUint64(&s.counters.sets, 1)
+			atomic.AddUint64(&s.counters.bytesWritten, uint64(size))
+			return version, nil
+		}
+		s.switchSegment(idx)
+		attempt++
+	}
+	if large != nil {
+		s.large.discard(large)
+	}
+	return 0, s.setError(&s.segments[atomic.LoadUint32(&s.index)], key)
+}
+
+// olderEntry returns segment and header address of the key entry stored in older generations than the supplied primary segment,
+// it is called under the primary key shard lock, thus it returns false instead of waiting for older generation key shard
+// locked for writing by concurrent operation, which may wait for the primary key shard in turn
+func (s *Cache) olderEntry(idx uint32, key []byte) (*segment, uint64, bool) {
+	for generation := uint32(1); generation < uint32(len(s.segments)); generation++ {
+		segment := &s.segments[s.olderIndex(idx, generation)]
+		headerAddress, locked := segment.getShardedMap().tryGetAddress(key, segment)
+		if !locked {
+			return nil, 0, false
+		}
+		if headerAddress != 0 {
+			return segment, headerAddress, true
+		}
+	}
+	return nil, 0, true
+}
+
+// entryVersion returns version of the entry stored at the supplied header address, zero for expired or negative entry
+func entryVersion(segment *segment, headerAddress uint64) uint64 {
+	if _, _, has := segment.entry(headerAddress); !has || segment.flags(headerAddress)&negativeEntry != 0 {
+		return 0
+	}
+	return segment.version(headerAddress)
+}
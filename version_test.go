@@ -0,0 +1,123 @@
+package scache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache_CompareAndSet(t *testing.T) {
+	cache, err := New(&Config{SizeMb: 1, Segments: 3})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cache.Close()
+
+	_, _, err = cache.GetWithVersion("key1")
+	assert.NotNil(t, err)
+	set, err := cache.SetIfAbsent("key1", []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, set)
+	set, err = cache.SetIfAbsent("key1", []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, set)
+
+	value, version, err := cache.GetWithVersion("key1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v1", string(value))
+	assert.NotEqual(t, uint64(0), version)
+
+	_, err = cache.CompareAndSet("key1", version+1, []byte("v2"))
+	mismatch, ok := err.(*VersionMismatch)
+	if assert.True(t, ok) {
+		assert.EqualValues(t, version, mismatch.Actual)
+	}
+	newVersion, err := cache.CompareAndSet("key1", version, []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, newVersion > version)
+
+	//version is preserved by promotion from older generation, which is checked by compare and set
+	cache.switchSegment(cache.index)
+	_, err = cache.CompareAndSet("key1", version, []byte("v3"))
+	assert.NotNil(t, err)
+	value, actualVersion, err := cache.GetWithVersion("key1")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v2", string(value))
+	assert.EqualValues(t, newVersion, actualVersion)
+	copied, copiedVersion, err := cache.GetWithVersionInto("key1", nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "v2", string(copied))
+	assert.EqualValues(t, newVersion, copiedVersion)
+	assert.Nil(t, cache.ViewWithVersion("key1", func(value []byte, version uint64) {
+		assert.EqualValues(t, "v2", string(value))
+		assert.EqualValues(t, newVersion, version)
+	}))
+	cache.switchSegment(cache.index)
+	_, err = cache.CompareAndSet("key1", newVersion, []byte("v3"))
+	assert.Nil(t, err)
+
+	//set changes version, deleted key matches zero version
+	assert.Nil(t, cache.Set("key1", []byte("v4")))
+	_, err = cache.CompareAndSet("key1", newVersion, []byte("v5"))
+	assert.NotNil(t, err)
+	assert.Nil(t, cache.Delete("key1"))
+	_, err = cache.CompareAndSet("key1", 0, []byte("v5"))
+	assert.Nil(t, err)
+}
+
+func TestCache_CompareAndSetConcurrency(t *testing.T) {
+	var useCases = []struct {
+		description string
+		switching   bool
+	}{
+		{
+			description: "primary segment",
+		},
+		{
+			description: "concurrent segment switches",
+			switching:   true,
+		},
+	}
+	for _, useCase := range useCases {
+		cache, err := New(&Config{SizeMb: 8, Segments: 8})
+		if !assert.Nil(t, err, useCase.description) {
+			continue
+		}
+		workers, increments := 8, 200
+		var waitGroup sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				var buffer []byte
+				switched := -1
+				for j := 0; j < increments; {
+					value, version, err := cache.GetWithVersionInto("counter", buffer)
+					buffer = value
+					counter := 0
+					if err == nil {
+						counter, _ = strconv.Atoi(string(value))
+					}
+					//segment switch between read and compare and set, segments ring wraps around recycling read segments
+					if useCase.switching && j%10 == 0 && switched != j {
+						switched = j
+						cache.switchSegment(atomic.LoadUint32(&cache.index))
+					}
+					if _, err = cache.CompareAndSet("counter", version, []byte(strconv.Itoa(counter+1))); err == nil {
+						j++
+					}
+				}
+			}()
+		}
+		waitGroup.Wait()
+		if useCase.switching {
+			assert.Greater(t, cache.Stats().Switches, uint64(len(cache.segments)), useCase.description)
+		}
+		value, err := cache.Get("counter")
+		assert.Nil(t, err, useCase.description)
+		assert.EqualValues(t, strconv.Itoa(workers*increments), string(value), useCase.description)
+		cache.Close()
+	}
+}
@@ -0,0 +1,86 @@
+package scache
+
+import "math/bits"
+
+// xxhash64 primes. See https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHasher represents seeded xxhash64 hasher
+type xxHasher struct {
+	seed uint64
+}
+
+// Sum64 returns xxhash64 of the key
+func (h xxHasher) Sum64(key []byte) uint64 {
+	n := len(key)
+	var hash uint64
+	i := 0
+	if n >= 32 {
+		v1 := h.seed + xxPrime1 + xxPrime2
+		v2 := h.seed + xxPrime2
+		v3 := h.seed
+		v4 := h.seed - xxPrime1
+		for ; i+32 <= n; i += 32 {
+			v1 = xxRound(v1, xxUint64(key, i))
+			v2 = xxRound(v2, xxUint64(key, i+8))
+			v3 = xxRound(v3, xxUint64(key, i+16))
+			v4 = xxRound(v4, xxUint64(key, i+24))
+		}
+		hash = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		hash = xxMergeRound(hash, v1)
+		hash = xxMergeRound(hash, v2)
+		hash = xxMergeRound(hash, v3)
+		hash = xxMergeRound(hash, v4)
+	} else {
+		hash = h.seed + xxPrime5
+	}
+	hash += uint64(n)
+	for ; i+8 <= n; i += 8 {
+		hash ^= xxRound(0, xxUint64(key, i))
+		hash = bits.RotateLeft64(hash, 27)*xxPrime1 + xxPrime4
+	}
+	if i+4 <= n {
+		hash ^= uint64(xxUint32(key, i)) * xxPrime1
+		hash = bits.RotateLeft64(hash, 23)*xxPrime2 + xxPrime3
+		i += 4
+	}
+	for ; i < n; i++ {
+		hash ^= uint64(key[i]) * xxPrime5
+		hash = bits.RotateLeft64(hash, 11) * xxPrime1
+	}
+	hash ^= hash >> 33
+	hash *= xxPrime2
+	hash ^= hash >> 29
+	hash *= xxPrime3
+	hash ^= hash >> 32
+	return hash
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
+
+func xxUint64(key []byte, i int) uint64 {
+	_ = key[i+7] //bounds check hint
+	return uint64(key[i]) | uint64(key[i+1])<<8 | uint64(key[i+2])<<16 | uint64(key[i+3])<<24 |
+		uint64(key[i+4])<<32 | uint64(key[i+5])<<40 | uint64(key[i+6])<<48 | uint64(key[i+7])<<56
+}
+
+func xxUint32(key []byte, i int) uint32 {
+	_ = key[i+3] //bounds check hint
+	return uint32(key[i]) | uint32(key[i+1])<<8 | uint32(key[i+2])<<16 | uint32(key[i+3])<<24
+}